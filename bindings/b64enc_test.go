@@ -2,6 +2,7 @@ package bindings
 
 import (
 	"testing"
+	"time"
 )
 
 func TestB64(t *testing.T) {
@@ -20,3 +21,38 @@ func TestB64(t *testing.T) {
 func TestCT(t *testing.T) {
 	t.Log((&B64{Key: []byte("hello"), IV: []byte("whatwhat")}).GetCT("hello"))
 }
+
+func TestSealer(t *testing.T) {
+	s := &Sealer{Key: []byte("hello")}
+	ct, err := s.Seal(&ClickToken{SaleID: 5, FolderID: 2, CreativeID: 3, Keyword: "cars", Expires: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tok ClickToken
+	if err := s.Open(ct, &tok); err != nil || tok.SaleID != 5 || tok.CreativeID != 3 || tok.Keyword != "cars" {
+		t.Errorf(`opened %v, %v`, tok, err)
+	}
+	if !tok.Expired(time.Unix(101, 0)) || tok.Expired(time.Unix(100, 0)) {
+		t.Error("expiry is wrong")
+	}
+
+	tampered := []byte(ct)
+	tampered[len(tampered)/2] ^= 1
+	if err := s.Open(string(tampered), &tok); err != ErrBadToken {
+		t.Error("opened a tampered token")
+	}
+	if err := (&Sealer{Key: []byte("other")}).Open(ct, &tok); err != ErrBadToken {
+		t.Error("opened a token with the wrong key")
+	}
+	if err := s.Open("not a token", &tok); err != ErrBadToken {
+		t.Error("opened garbage")
+	}
+
+	keyless := &Sealer{}
+	if _, err := keyless.Seal(&tok); err != ErrNoKey {
+		t.Error("sealed without a key")
+	}
+	if err := keyless.Open(ct, &tok); err != ErrNoKey {
+		t.Error("opened without a key")
+	}
+}
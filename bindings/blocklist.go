@@ -0,0 +1,195 @@
+package bindings
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/clixxa/dsp/services"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const sqlBlocklist = `SELECT entry FROM blocklist`
+const sqlFolderBlocklist = `SELECT entry FROM folder_blocklist WHERE folder_id = ?`
+
+// Blocklist is a brand safety list of domains (blocking their subdomains too) and domain/path
+// prefixes, eg example.com or example.com/news/politics. Domains are kept in a trie of their
+// labels from the tld down, so a lookup is one step per label of the page's host. Matching
+// ignores case and paths only match whole segments (/news doesn't block /newsletter).
+type Blocklist struct {
+	Problems []error
+
+	root *domainNode
+	n    int
+}
+
+type domainNode struct {
+	children map[string]*domainNode
+	whole    bool
+	paths    []string
+}
+
+// Unmarshal loads the global blocklist from the config db.
+func (b *Blocklist) Unmarshal(depth int, env services.BindingDeps) error {
+	rows, err := env.ConfigDB.Query(sqlBlocklist)
+	if err != nil {
+		env.Debug.Println("err", err)
+		return err
+	}
+	var entry string
+	for rows.Next() {
+		if err := rows.Scan(&entry); err != nil {
+			env.Debug.Println("err", err)
+			return err
+		}
+		if err := b.Add(entry); err != nil {
+			env.Debug.Println("err", err)
+			b.Problems = append(b.Problems, err)
+		}
+	}
+	env.Debug.Printf("LOADED %s %T %d entries", wide(depth), b, b.Len())
+	return nil
+}
+
+// AddFile adds every line of a file, ignoring blank lines and # comments. Bad lines go in Problems.
+func (b *Blocklist) AddFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if err := b.Add(line); err != nil {
+			b.Problems = append(b.Problems, fmt.Errorf(`%s: %s`, path, err))
+		}
+	}
+	return sc.Err()
+}
+
+func splitEntry(entry string) (labels []string, path string, err error) {
+	e := strings.ToLower(strings.TrimSpace(entry))
+	if i := strings.Index(e, "://"); i >= 0 {
+		e = e[i+3:]
+	}
+	host := e
+	if i := strings.IndexByte(e, '/'); i >= 0 {
+		host, path = e[:i], strings.TrimRight(e[i:], "/")
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(host, "*"), "."), ".")
+	if host == "" || strings.ContainsAny(host, " :?#*") {
+		return nil, "", fmt.Errorf(`bad blocklist entry %q`, entry)
+	}
+	labels = strings.Split(host, ".")
+	for _, l := range labels {
+		if l == "" {
+			return nil, "", fmt.Errorf(`bad blocklist entry %q`, entry)
+		}
+	}
+	return labels, path, nil
+}
+
+func (b *Blocklist) Add(entry string) error {
+	labels, path, err := splitEntry(entry)
+	if err != nil {
+		return err
+	}
+	if b.root == nil {
+		b.root = &domainNode{}
+	}
+	n := b.root
+	for i := len(labels) - 1; i >= 0; i-- {
+		if n.children == nil {
+			n.children = make(map[string]*domainNode)
+		}
+		next := n.children[labels[i]]
+		if next == nil {
+			next = &domainNode{}
+			n.children[labels[i]] = next
+		}
+		n = next
+	}
+	if path == "" {
+		n.whole = true
+	} else {
+		n.paths = append(n.paths, path)
+	}
+	b.n++
+	return nil
+}
+
+func (b *Blocklist) Len() int {
+	if b == nil {
+		return 0
+	}
+	return b.n
+}
+
+// Page is a page url parsed once per request, for checking against any number of blocklists.
+// Bad pages didn't parse.
+type Page struct {
+	URL    string
+	Labels []string
+	Path   string
+	Bad    bool
+}
+
+// ParsePage lowercases and splits up a page url. Urls without a scheme are taken as http.
+func ParsePage(page string) *Page {
+	p := &Page{URL: page}
+	if page == "" {
+		return p
+	}
+	if !strings.Contains(page, "://") {
+		page = "http://" + page
+	}
+	u, err := url.Parse(page)
+	if err != nil || u.Hostname() == "" {
+		p.Bad = true
+		return p
+	}
+	p.Labels = strings.Split(strings.TrimSuffix(strings.ToLower(u.Hostname()), "."), ".")
+	p.Path = strings.ToLower(u.Path)
+	return p
+}
+
+// Blocks is whether the page url is on the list, see BlocksPage.
+func (b *Blocklist) Blocks(page string) bool {
+	if b == nil || b.root == nil {
+		return false
+	}
+	return b.BlocksPage(ParsePage(page))
+}
+
+// BlocksPage is whether the page is on the list. Requests without a page aren't blocked, but
+// pages that don't parse are, as there's no telling where they are.
+func (b *Blocklist) BlocksPage(page *Page) bool {
+	if b == nil || b.root == nil || page == nil || page.URL == "" {
+		return false
+	}
+	if page.Bad {
+		return true
+	}
+	n := b.root
+	for i := len(page.Labels) - 1; i >= 0; i-- {
+		if n = n.children[page.Labels[i]]; n == nil {
+			return false
+		}
+		if n.whole {
+			return true
+		}
+		for _, p := range n.paths {
+			if strings.HasPrefix(page.Path, p) && (len(page.Path) == len(p) || page.Path[len(p)] == '/') {
+				return true
+			}
+		}
+	}
+	return false
+}
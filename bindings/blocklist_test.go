@@ -0,0 +1,68 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"testing"
+)
+
+func TestBlocklist(t *testing.T) {
+	b := &Blocklist{}
+	for _, e := range []string{"bad.com", "*.worse.org", "news.com/politics/", "HTTP://Mixed.com/Path"} {
+		if err := b.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, e := range []string{"", "bad..com", "bad.com:80", "/just/a/path"} {
+		if err := b.Add(e); err == nil {
+			t.Errorf(`%q should be a bad entry`, e)
+		}
+	}
+
+	for page, blocked := range map[string]bool{
+		"http://bad.com/":                      true,
+		"https://www.BAD.com/anything?x=1":     true,
+		"bad.com":                              true,
+		"http://notbad.com/":                   false,
+		"http://bad.com.au/":                   false,
+		"http://a.b.worse.org/":                true,
+		"http://news.com/":                     false,
+		"http://news.com/politics":             true,
+		"http://www.news.com/politics/today":   true,
+		"http://news.com/politicsandmore":      false,
+		"http://mixed.com/path/page":           true,
+		"":                                     false,
+		"http://example.com/?u=http://bad.com": false,
+		"http://%zz/":                          true,
+		"http://":                              true,
+	} {
+		if b.Blocks(page) != blocked {
+			t.Errorf(`%s: expected blocked %v`, page, blocked)
+		}
+	}
+	if (*Blocklist)(nil).Blocks("http://bad.com") {
+		t.Error("a nil blocklist blocks nothing")
+	}
+}
+
+func TestFolderBlocklist(t *testing.T) {
+	f := &Folder{ID: 1, Blocklist: &Blocklist{}}
+	f.Blocklist.Add("news.com/politics")
+	open := &Folder{ID: 2}
+
+	r := &rtb_types.Request{}
+	d := &rtb_types.Dimensions{}
+	for page, targets := range map[string]bool{
+		"http://news.com/politics/today": false,
+		"http://news.com/sport":          true,
+		"":                               true,
+	} {
+		r.Site.Placement = page
+		p := ParsePage(page)
+		if f.Targets(r, d, p) != targets {
+			t.Errorf(`%q: expected targets %v`, page, targets)
+		}
+		if !open.Targets(r, d, p) {
+			t.Errorf(`%q: a folder without a blocklist should target it`, page)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"strings"
+)
+
+// Block rules, also the names creatives removed by them are counted under (prefixed blocked_).
+const (
+	BlockAttribute  = "battr"
+	BlockCategory   = "bcat"
+	BlockAdvertiser = "badv"
+)
+
+// Blocked returns the rule that stops the creative being shown for the impression, or "" if none do.
+// A blocked category blocks its subcategories (IAB7 blocks IAB7-1), a blocked domain its subdomains.
+func (c *Creative) Blocked(r *rtb_types.Request, imp *rtb_types.Impression) string {
+	if imp != nil {
+		for _, battr := range imp.Redirect.BannedAttributes {
+			for _, attr := range c.Attributes {
+				if strings.EqualFold(attr, battr) {
+					return BlockAttribute
+				}
+			}
+		}
+	}
+	for _, bcat := range r.BlockedCats {
+		for _, cat := range c.Categories {
+			if strings.EqualFold(cat, bcat) || hasFoldPrefix(cat, bcat+"-") {
+				return BlockCategory
+			}
+		}
+	}
+	for _, badv := range r.BlockedAdvs {
+		badv = strings.TrimPrefix(strings.ToLower(badv), ".")
+		for _, domain := range c.Domains {
+			domain = strings.ToLower(domain)
+			if domain == badv || strings.HasSuffix(domain, "."+badv) {
+				return BlockAdvertiser
+			}
+		}
+	}
+	return ""
+}
+
+func hasFoldPrefix(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// Eligible filters a folder's creatives down to those that are live and not blocked by the request,
+// counting each creative removed against the ssp under the rule that removed it.
+func (c *Creatives) Eligible(ids []int, r *rtb_types.Request, imp *rtb_types.Impression, counters *services.Counters, ssp int) []int {
+	var eligible []int
+	for _, id := range ids {
+		cr := c.ByID(id)
+		if cr == nil || !cr.Active {
+			continue
+		}
+		if rule := cr.Blocked(r, imp); rule != "" {
+			counters.Inc(ssp, "blocked_"+rule)
+			continue
+		}
+		eligible = append(eligible, id)
+	}
+	return eligible
+}
+
+// Describe copies the creative's attributes, categories and advertiser domains onto a bid.
+func (c *Creative) Describe(b *rtb_types.Bid) {
+	b.Attributes, b.Categories, b.Domains = c.Attributes, c.Categories, c.Domains
+}
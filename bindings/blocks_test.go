@@ -0,0 +1,31 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"testing"
+)
+
+func TestEligibleCreatives(t *testing.T) {
+	creatives := Creatives{
+		{ID: 1, Active: true, Attributes: []string{"3"}},
+		{ID: 2, Active: true, Categories: []string{"IAB7-1"}},
+		{ID: 3, Active: true, Domains: []string{"www.Example.com"}},
+		{ID: 4, Active: true, Categories: []string{"IAB70"}, Domains: []string{"notexample.com"}},
+		{ID: 5, Active: false},
+	}
+	r := &rtb_types.Request{BlockedCats: []string{"iab7"}, BlockedAdvs: []string{"example.com"}}
+	imp := &rtb_types.Impression{}
+	imp.Redirect.BannedAttributes = []string{"3"}
+	counters := &services.Counters{}
+
+	eligible := creatives.Eligible([]int{1, 2, 3, 4, 5}, r, imp, counters, 9)
+	if len(eligible) != 1 || eligible[0] != 4 {
+		t.Errorf(`expected only creative 4 to be eligible, have %v`, eligible)
+	}
+	for _, rule := range []string{BlockAttribute, BlockCategory, BlockAdvertiser} {
+		if counters.Get(9, "blocked_"+rule) != 1 {
+			t.Errorf(`%s wasn't counted`, rule)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package bindings
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/clixxa/dsp/services"
+	"time"
+)
+
+// conversionColumns are copied from the sale's purchases row, so conversions report like purchases.
+const conversionColumns = `ssp_id, folder_id, creative_id, country_id, vertical_id, brand_id, network_id, subnetwork_id, networktype_id, gender_id, devicetype_id, subchannel_id, deal_id, placement`
+
+const sqlCreateConversions = `CREATE TABLE conversions (
+	created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	sale_id int NOT NULL,
+	click_id varchar(64) NOT NULL DEFAULT '',
+	txid varchar(255) NOT NULL DEFAULT '',
+	value int NOT NULL DEFAULT 0,
+	ssp_id int NOT NULL,
+
+	folder_id int NOT NULL,
+	creative_id int NOT NULL,
+
+	country_id int NOT NULL,
+	vertical_id int NOT NULL,
+	brand_id int NOT NULL,
+	network_id int NOT NULL,
+	subnetwork_id int NOT NULL,
+	networktype_id int NOT NULL,
+	gender_id int NOT NULL,
+	devicetype_id int NOT NULL,
+	subchannel_id int NOT NULL,
+	deal_id varchar(255) NOT NULL,
+	placement varchar(255) NOT NULL,
+	UNIQUE (sale_id, txid)
+);`
+
+const sqlClickSale = `SELECT sale_id, created_at FROM clicks WHERE click_id = $1`
+const sqlPurchaseTime = `SELECT created_at FROM purchases WHERE sale_id = $1 LIMIT 1`
+const sqlInsertConversion = `INSERT INTO conversions (sale_id, click_id, txid, value, ` + conversionColumns + `) SELECT sale_id, $2, $3, $4, ` + conversionColumns + ` FROM purchases WHERE sale_id = $1 LIMIT 1 ON CONFLICT DO NOTHING RETURNING ssp_id, folder_id, creative_id`
+
+var (
+	ErrUnknownSale      = errors.New("no sale or click with that id")
+	ErrOutsideWindow    = errors.New("outside the attribution window")
+	ErrDuplicateConvert = errors.New("conversion already recorded")
+)
+
+// Conversion is an advertiser's postback. It names either the click or the sale, TxID tells
+// apart several conversions on one sale and Value is in home currency wire units.
+type Conversion struct {
+	SaleID  int
+	ClickID string
+	TxID    string
+	Value   int
+
+	// filled in from the sale once attributed
+	SSPID      int
+	FolderID   int
+	CreativeID int
+}
+
+// Conversions attributes conversions to sales in the stats db. A conversion counts when it arrives
+// within Window of the click (or the sale, when there's no click id), and only once per sale and txid.
+type Conversions struct {
+	Env    services.BindingDeps
+	Window time.Duration
+}
+
+func (s Conversions) Save(ctx context.Context, c *Conversion) error {
+	var clicked, bought time.Time
+	if c.ClickID != "" {
+		err := s.Env.StatsDB.QueryRowContext(ctx, sqlClickSale, c.ClickID).Scan(&c.SaleID, &clicked)
+		if err == sql.ErrNoRows {
+			return ErrUnknownSale
+		} else if err != nil {
+			return err
+		}
+	}
+	// a click whose purchase isn't recorded can't be attributed, and would otherwise look like a
+	// duplicate when the insert copies nothing
+	err := s.Env.StatsDB.QueryRowContext(ctx, sqlPurchaseTime, c.SaleID).Scan(&bought)
+	if err == sql.ErrNoRows {
+		return ErrUnknownSale
+	} else if err != nil {
+		return err
+	}
+	at := bought
+	if c.ClickID != "" {
+		at = clicked
+	}
+
+	window := s.Window
+	if window == 0 {
+		window = 30 * 24 * time.Hour
+	}
+	if time.Since(at) > window {
+		return ErrOutsideWindow
+	}
+
+	err = s.Env.StatsDB.QueryRowContext(ctx, sqlInsertConversion, c.SaleID, c.ClickID, c.TxID, c.Value).Scan(&c.SSPID, &c.FolderID, &c.CreativeID)
+	if err == sql.ErrNoRows {
+		return ErrDuplicateConvert
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package bindings
+
+import (
+	"fmt"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"os"
+	"strings"
+	"sync"
+)
+
+const sqlFXRates = `SELECT currency, rate FROM fx_rates`
+
+// FXRates converts amounts between currencies and our home currency (THOMECURRENCY, else USD).
+// Rates are how much of a currency one home unit buys, reloaded from the config db each cycle.
+// Amounts keep their units, so a wire price converts to a wire price in the other currency.
+// The last rates loaded are kept when a reload fails.
+type FXRates struct {
+	BindingDeps services.BindingDeps
+	Home        string
+	Rates       map[string]float64
+
+	lock sync.RWMutex
+}
+
+func (fx *FXRates) Cycle(quit func(error) bool) {
+	if fx.BindingDeps.ConfigDB == nil {
+		return
+	}
+	err := fx.Unmarshal(0, fx.BindingDeps)
+	quit(services.ErrDatabaseMissing{Name: "fx rates", UnderlyingErr: err})
+}
+
+func (fx *FXRates) Unmarshal(depth int, env services.BindingDeps) error {
+	fx.lock.RLock()
+	home := fx.Home
+	fx.lock.RUnlock()
+	if home == "" {
+		home = strings.ToUpper(os.Getenv("THOMECURRENCY"))
+	}
+	if home == "" {
+		home = rtb_types.DefaultCurrency
+	}
+
+	rows, err := env.ConfigDB.Query(sqlFXRates)
+	if err != nil {
+		env.Debug.Println("err", err)
+		return err
+	}
+	rates := map[string]float64{home: 1}
+	for rows.Next() {
+		var cur string
+		var rate float64
+		if err := rows.Scan(&cur, &rate); err != nil {
+			env.Debug.Println("err", err)
+			return err
+		}
+		if rate <= 0 {
+			env.Debug.Println("err", fmt.Errorf(`ignoring rate %f for %s`, rate, cur))
+			continue
+		}
+		rates[strings.ToUpper(cur)] = rate
+	}
+	fx.lock.Lock()
+	fx.Home, fx.Rates = home, rates
+	fx.lock.Unlock()
+
+	env.Debug.Printf("LOADED %s %T %s", wide(depth), fx, tojson(rates))
+	return nil
+}
+
+type ErrUnknownCurrency string
+
+func (e ErrUnknownCurrency) Error() string {
+	return "no exchange rate for " + string(e)
+}
+
+func (fx *FXRates) rate(cur string) (float64, error) {
+	cur = strings.ToUpper(cur)
+	if cur == "" {
+		cur = rtb_types.DefaultCurrency
+	}
+	fx.lock.RLock()
+	defer fx.lock.RUnlock()
+	home := fx.Home
+	if home == "" {
+		home = rtb_types.DefaultCurrency
+	}
+	if cur == home {
+		return 1, nil
+	}
+	if r, ok := fx.Rates[cur]; ok {
+		return r, nil
+	}
+	return 0, ErrUnknownCurrency(cur)
+}
+
+// ToHome converts an amount in cur (the default currency when empty) to the home currency.
+func (fx *FXRates) ToHome(amount float64, cur string) (float64, error) {
+	r, err := fx.rate(cur)
+	if err != nil {
+		return 0, err
+	}
+	return amount / r, nil
+}
+
+// FromHome converts an amount in the home currency to cur.
+func (fx *FXRates) FromHome(amount float64, cur string) (float64, error) {
+	r, err := fx.rate(cur)
+	if err != nil {
+		return 0, err
+	}
+	return amount * r, nil
+}
+
+// FloorHome is the impression's floor (or the deal's, see DealMatch.Floor) in the home currency.
+func (fx *FXRates) FloorHome(imp *rtb_types.Impression, m *DealMatch) (float64, error) {
+	floor, cur := m.Floor(imp)
+	return fx.ToHome(float64(floor), cur)
+}
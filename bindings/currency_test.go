@@ -0,0 +1,27 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"testing"
+)
+
+func TestFXRates(t *testing.T) {
+	fx := &FXRates{Home: "USD", Rates: map[string]float64{"EUR": 0.8, "JPY": 150}}
+	if v, err := fx.ToHome(800, "eur"); err != nil || v != 1000 {
+		t.Errorf(`800 eur is %f usd (%v), expected 1000`, v, err)
+	}
+	if v, err := fx.FromHome(2, "JPY"); err != nil || v != 300 {
+		t.Errorf(`2 usd is %f jpy (%v), expected 300`, v, err)
+	}
+	if v, err := fx.ToHome(5, ""); err != nil || v != 5 {
+		t.Errorf(`no currency should be usd, got %f (%v)`, v, err)
+	}
+	if _, err := fx.ToHome(5, "GBP"); err != ErrUnknownCurrency("GBP") {
+		t.Errorf(`expected an unknown currency, got %v`, err)
+	}
+
+	imp := &rtb_types.Impression{BidFloor: 1600, BidFloorCur: "EUR"}
+	if floor, err := fx.FloorHome(imp, nil); err != nil || floor != 2000 {
+		t.Errorf(`floor is %f (%v), expected 2000`, floor, err)
+	}
+}
@@ -1,9 +1,11 @@
 package bindings
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"github.com/clixxa/dsp/rtb_types"
 	"github.com/clixxa/dsp/services"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/lib/pq"
@@ -27,9 +29,18 @@ const sqlUser = `SELECT setting_id, value FROM user_settings WHERE user_id = ?`
 const sqlDimention = `SELECT dimentions_id, dimentions_type FROM dimentions WHERE folder_id = ?`
 const sqlDimension = `SELECT dimensions_id, dimensions_type FROM dimensions WHERE folder_id = ?`
 const sqlFolder = `SELECT budget, bid, creative_id, user_id, folders.status, folders.deleted_at, creative_folder.status, creative_folder.deleted_at, folders.placement_list_type FROM folders LEFT JOIN creative_folder ON folder_id = id WHERE id = ? ORDER BY creative_folder.updated_at DESC, creative_folder.created_at DESC`
+const sqlFolderCreatives = `SELECT creative_id, COALESCE(weight, 1) FROM creative_folder WHERE folder_id = ? AND status = 'live' AND deleted_at IS NULL ORDER BY updated_at DESC, created_at DESC`
 const sqlFolderPlacements = `SELECT pattern FROM folder_placements WHERE folder_id = ?`
 const sqlFolderKeywords = `SELECT name FROM folder_keywords WHERE folder_id = ?`
+const sqlFolderRegions = `SELECT region FROM folder_regions WHERE folder_id = ?`
+const sqlFolderCities = `SELECT city FROM folder_cities WHERE folder_id = ?`
+const sqlFolderSettings = `SELECT setting_id, value FROM folder_settings WHERE folder_id = ?`
+const sqlFolderModifiers = `SELECT dimension_type, dimension_value, multiplier FROM folder_bid_modifiers WHERE folder_id = ?`
+const sqlFolderRanges = `SELECT field, min_value, max_value, COALESCE(multiplier, 1) FROM folder_ranges WHERE folder_id = ?`
 const sqlCreative = `SELECT destination_url, deleted_at FROM creatives cr WHERE cr.id = ?`
+const sqlCreativeAttributes = `SELECT attribute FROM creative_attributes WHERE creative_id = ?`
+const sqlCreativeCategories = `SELECT category FROM creative_categories WHERE creative_id = ?`
+const sqlCreativeDomains = `SELECT domain FROM creative_domains WHERE creative_id = ?`
 const sqlCountries = `SELECT id, iso_2alpha FROM countries`
 const sqlNetworks = `SELECT id, pseudonym FROM networks`
 const sqlSubNetworks = `SELECT id, pseudonym FROM subnetworks`
@@ -74,6 +85,10 @@ type Pseudonyms struct {
 
 	DeviceTypes   map[string]int
 	DeviceTypeIDs map[int]string
+	OSes          map[string]int
+	OSIDs         map[int]string
+	Browsers      map[string]int
+	BrowserIDs    map[int]string
 	Genders       map[string]int
 	GenderIDs     map[int]string
 
@@ -105,6 +120,10 @@ func (c *Pseudonyms) Unmarshal(depth int, env services.BindingDeps) error {
 
 	c.DeviceTypes = map[string]int{"desktop": 1, "mobile": 2, "tablet": 3, "unknown": 4}
 	c.DeviceTypeIDs = map[int]string{1: "desktop", 2: "mobile", 3: "tablet", 4: "unknown"}
+	c.OSes = map[string]int{"windows": 1, "macos": 2, "linux": 3, "chromeos": 4, "android": 5, "ios": 6, "windows phone": 7, "other": 8}
+	c.OSIDs = map[int]string{1: "windows", 2: "macos", 3: "linux", 4: "chromeos", 5: "android", 6: "ios", 7: "windows phone", 8: "other"}
+	c.Browsers = map[string]int{"chrome": 1, "safari": 2, "firefox": 3, "edge": 4, "ie": 5, "opera": 6, "samsung": 7, "uc": 8, "other": 9}
+	c.BrowserIDs = map[int]string{1: "chrome", 2: "safari", 3: "firefox", 4: "edge", 5: "ie", 6: "opera", 7: "samsung", 8: "uc", 9: "other"}
 	c.Genders = map[string]int{"male": 1, "female": 2}
 	c.GenderIDs = map[int]string{1: "male", 2: "female"}
 
@@ -169,10 +188,19 @@ func (c *Pseudonyms) SubchannelLoad(env services.BindingDeps, sql string, dest *
 			return err
 		}
 		(*dest)[Subchannel{ChannelID: channelId, Label: label}] = id
+		(*dest)[Subchannel{ChannelID: channelId, Label: strings.ToLower(label)}] = id
 	}
 	return nil
 }
 
+// SubchannelID resolves a subchannel label sent by an ssp, the channel being the request's network.
+func (c *Pseudonyms) SubchannelID(channelID int, label string) int {
+	if id, ok := c.Subchannels[Subchannel{ChannelID: channelID, Label: label}]; ok {
+		return id
+	}
+	return c.Subchannels[Subchannel{ChannelID: channelID, Label: strings.ToLower(label)}]
+}
+
 type Users []*User
 
 func (f *Users) ByID(id int) *User {
@@ -248,7 +276,43 @@ type User struct {
 	Status       int
 	AuthKey      string
 	PublisherURL string
+	QPS          int
+	Priority     int
+	TMax         int
+	Currency     string
+	Margin       float64
+	Postbacks    []string
 	Deals        Deals
+	Problems     []error
+}
+
+// ParsePostbackEvents reads user_settings 14, a comma separated list of the events an ssp wants
+// postbacks for.
+func ParsePostbackEvents(value string) []string {
+	var events []string
+	for _, e := range strings.Split(value, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// PostbackURL is the publisher url to notify of an event, if the ssp has one and wants the event.
+// SSPs that don't list their events (user_settings 14) get all of them.
+func (u *User) PostbackURL(event string) (string, bool) {
+	if u == nil || u.PublisherURL == "" {
+		return "", false
+	}
+	if len(u.Postbacks) == 0 {
+		return u.PublisherURL, true
+	}
+	for _, e := range u.Postbacks {
+		if e == event {
+			return u.PublisherURL, true
+		}
+	}
+	return "", false
 }
 
 type Deal struct {
@@ -269,7 +333,7 @@ func (dls *Deals) ByID(id string) *Deal {
 	return nil
 }
 
-const getUserDealSql = `SELECT deals.id, fixed_revshare, fixed_cpc, priority FROM deals LEFT JOIN deal_user AS du ON du.deal_id = deals.id WHERE du.user_id = $1`
+const getUserDealSql = `SELECT deals.id, fixed_revshare, fixed_cpc, priority FROM deals LEFT JOIN deal_user AS du ON du.deal_id = deals.id WHERE du.user_id = ?`
 
 func (u *User) GetDeals(env services.BindingDeps) error {
 	rows, err := env.ConfigDB.Query(getUserDealSql, u.ID)
@@ -277,13 +341,19 @@ func (u *User) GetDeals(env services.BindingDeps) error {
 		env.Debug.Println("err", err)
 		return err
 	}
-	var deal Deal
+	u.Deals = u.Deals[:0]
 	for rows.Next() {
-		if err := rows.Scan(&deal); err != nil {
+		deal := &Deal{}
+		var revShare sql.NullFloat64
+		var cpc, priority sql.NullInt64
+		if err := rows.Scan(&deal.ID, &revShare, &cpc, &priority); err != nil {
 			env.Debug.Println("err", err)
 			return err
 		}
-		u.Deals = append(u.Deals, &deal)
+		deal.FixedRevShare = revShare.Float64
+		deal.FixedCPC = int(cpc.Int64)
+		deal.Priority = int(priority.Int64)
+		u.Deals = append(u.Deals, deal)
 	}
 	return nil
 }
@@ -325,10 +395,29 @@ func (u *User) Unmarshal(depth int, env services.BindingDeps) error {
 				u.AuthKey = value
 			case 8:
 				u.PublisherURL = value
+			case 9:
+				u.QPS, _ = strconv.Atoi(value)
+			case 10:
+				u.Priority, _ = strconv.Atoi(value)
+			case 11:
+				u.TMax, _ = strconv.Atoi(value)
+			case 12:
+				u.Currency = strings.ToUpper(value)
+			case 13:
+				u.Margin, _ = strconv.ParseFloat(value, 64)
+			case 14:
+				u.Postbacks = ParsePostbackEvents(value)
 			}
 		}
 	}
 
+	// an ssp whose deals didn't load still bids, just not on deals
+	if err := u.GetDeals(env); err != nil {
+		env.Debug.Println("err", err)
+		u.Deals = nil
+		u.Problems = append(u.Problems, err)
+	}
+
 	s := strings.Split(env.DefaultKey, ":")
 	key, iv := s[0], s[1]
 	if u.Key != "" {
@@ -430,6 +519,15 @@ func (d *Dimension) Transfer(f *Folder) error {
 	case `CurrentInterest`:
 		f.Interest = append(f.Interest, d.Value)
 		return nil
+	case `Subchannel`:
+		f.Subchannel = append(f.Subchannel, d.Value)
+		return nil
+	case `OS`:
+		f.OS = append(f.OS, d.Value)
+		return nil
+	case `Browser`:
+		f.Browser = append(f.Browser, d.Value)
+		return nil
 	default:
 		return fmt.Errorf(`unknown type: %s`, d.Type)
 	}
@@ -440,6 +538,8 @@ type Folder struct {
 	ParentID *int
 	Children []int
 	Creative []int
+	Weights  []float64
+	Rotation string
 	CPC      int
 	Budget   int
 	OwnerID  int
@@ -454,12 +554,23 @@ type Folder struct {
 	DeviceType          []int
 	Angle               []int
 	Interest            []int
+	Subchannel          []int
+	OS                  []int
+	Browser             []int
+	Region              []string
+	City                []string
+	Ranges              []*Range
+	Modifiers           []*BidModifier
 	Keywords            []string
 	Placement           []string
 	PlacementFilterType string
+	Targeting           *Expression
+	NoShading           bool
+	Blocklist           *Blocklist
 
 	Active             bool
 	MaxImpressionCount int
+	Problems           []error
 
 	mode int
 }
@@ -487,17 +598,9 @@ func (f *Folder) Unmarshal(depth int, env services.BindingDeps) error {
 			f.Active = true
 		}
 	}
-	if creativeLive.Valid {
-		if creativeLive.String != "live" {
-			f.Active = false
-		}
-	}
 	if folder_deleted_at.Valid {
 		f.Active = false
 	}
-	if creative_deleted_at.Valid {
-		f.Active = false
-	}
 
 	if budget.Valid && creative_id.Valid {
 		f.Budget = int(budget.Int64)
@@ -517,7 +620,29 @@ func (f *Folder) Unmarshal(depth int, env services.BindingDeps) error {
 	}
 
 	if creative_id.Valid {
-		f.Creative = append(f.Creative, int(creative_id.Int64))
+		rows, err := env.ConfigDB.Query(sqlFolderCreatives, f.ID)
+		if err != nil {
+			return err
+		}
+		var id int
+		var weight float64
+		for rows.Next() {
+			if err := rows.Scan(&id, &weight); err != nil {
+				env.Debug.Println("err", err)
+				return err
+			}
+			if weight < 0 {
+				err := fmt.Errorf(`creative %d has negative weight %f`, id, weight)
+				env.Debug.Println("err", err)
+				f.Problems = append(f.Problems, err)
+				continue
+			}
+			f.Creative = append(f.Creative, id)
+			f.Weights = append(f.Weights, weight)
+		}
+		if len(f.Creative) == 0 {
+			f.Active = false
+		}
 	}
 
 	{
@@ -568,6 +693,170 @@ func (f *Folder) Unmarshal(depth int, env services.BindingDeps) error {
 		}
 	}
 
+	{
+		rows, err := env.ConfigDB.Query(sqlFolderRegions, f.ID)
+		if err != nil {
+			return err
+		}
+		var region string
+		for rows.Next() {
+			if err := rows.Scan(&region); err != nil {
+				env.Debug.Println("err", err)
+				return err
+			}
+			f.Region = append(f.Region, region)
+		}
+	}
+
+	{
+		rows, err := env.ConfigDB.Query(sqlFolderCities, f.ID)
+		if err != nil {
+			return err
+		}
+		var city string
+		for rows.Next() {
+			if err := rows.Scan(&city); err != nil {
+				env.Debug.Println("err", err)
+				return err
+			}
+			f.City = append(f.City, city)
+		}
+	}
+
+	{
+		rows, err := env.ConfigDB.Query(sqlFolderRanges, f.ID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var min, max sql.NullInt64
+			rg := &Range{}
+			if err := rows.Scan(&rg.Field, &min, &max, &rg.Multiplier); err != nil {
+				env.Debug.Println("err", err)
+				return err
+			}
+			if min.Valid {
+				v := int(min.Int64)
+				rg.Min = &v
+			}
+			if max.Valid {
+				v := int(max.Int64)
+				rg.Max = &v
+			}
+			if err := rg.Check(); err != nil {
+				env.Debug.Println("err", err)
+				f.Problems = append(f.Problems, err)
+				f.Active = false
+				continue
+			}
+			f.Ranges = append(f.Ranges, rg)
+		}
+	}
+
+	{
+		rows, err := env.ConfigDB.Query(sqlFolderModifiers, f.ID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			m := &BidModifier{}
+			if err := rows.Scan(&m.Type, &m.Value, &m.Multiplier); err != nil {
+				env.Debug.Println("err", err)
+				return err
+			}
+			if err := m.Check(); err != nil {
+				env.Debug.Println("err", err)
+				f.Problems = append(f.Problems, err)
+				f.Active = false
+				continue
+			}
+			f.Modifiers = append(f.Modifiers, m)
+		}
+	}
+
+	{
+		rows, err := env.ConfigDB.Query(sqlFolderBlocklist, f.ID)
+		if err != nil {
+			return err
+		}
+		var entry string
+		for rows.Next() {
+			if err := rows.Scan(&entry); err != nil {
+				env.Debug.Println("err", err)
+				return err
+			}
+			if f.Blocklist == nil {
+				f.Blocklist = &Blocklist{}
+			}
+			if err := f.Blocklist.Add(entry); err != nil {
+				env.Debug.Println("err", err)
+				f.Problems = append(f.Problems, err)
+				f.Active = false
+			}
+		}
+	}
+
+	{
+		rows, err := env.ConfigDB.Query(sqlFolderSettings, f.ID)
+		if err != nil {
+			return err
+		}
+		var value string
+		var setting int
+		for rows.Next() {
+			if err := rows.Scan(&setting, &value); err != nil {
+				env.Debug.Println("err", err)
+				return err
+			}
+			switch setting {
+			case 1:
+				if strings.TrimSpace(value) == "" {
+					continue
+				}
+				expr, err := CompileExpression(value)
+				if err != nil {
+					env.Debug.Println("err", err)
+					f.Problems = append(f.Problems, fmt.Errorf(`targeting %q: %s`, value, err))
+					f.Active = false
+					continue
+				}
+				f.Targeting = expr
+			case 2:
+				noShading, err := strconv.ParseBool(strings.TrimSpace(value))
+				if err != nil {
+					env.Debug.Println("err", err)
+					f.Problems = append(f.Problems, fmt.Errorf(`no shading %q: %s`, value, err))
+					f.Active = false
+					continue
+				}
+				f.NoShading = noShading
+			case 3:
+				switch rotation := strings.ToLower(strings.TrimSpace(value)); rotation {
+				case RotateWeighted, RotateThompson:
+					f.Rotation = rotation
+				default:
+					err := fmt.Errorf(`unknown creative rotation %q`, value)
+					env.Debug.Println("err", err)
+					f.Problems = append(f.Problems, err)
+					f.Active = false
+				}
+			case 4:
+				if f.Blocklist == nil {
+					f.Blocklist = &Blocklist{}
+				}
+				problems := len(f.Blocklist.Problems)
+				if err := f.Blocklist.AddFile(strings.TrimSpace(value)); err != nil {
+					env.Debug.Println("err", err)
+					f.Blocklist.Problems = append(f.Blocklist.Problems, err)
+				}
+				if len(f.Blocklist.Problems) > problems {
+					f.Problems = append(f.Problems, f.Blocklist.Problems[problems:]...)
+					f.Active = false
+				}
+			}
+		}
+	}
+
 	{
 		rows, err := env.ConfigDB.Query(`SELECT parent_folder_id FROM parent_folder WHERE child_folder_id = ?`, f.ID)
 		if err != nil {
@@ -604,8 +893,51 @@ func (f *Folder) Unmarshal(depth int, env services.BindingDeps) error {
 	return nil
 }
 
+// Targets checks the folder's targeting that isn't covered by its dimension lists. page is
+// ParsePage of the request's placement, parsed once for all the folders.
+func (f *Folder) Targets(r *rtb_types.Request, d *rtb_types.Dimensions, page *Page) bool {
+	if !anyOf(f.Subchannel, d.SubchannelID) || !anyOf(f.OS, d.OSID) || !anyOf(f.Browser, d.BrowserID) {
+		return false
+	}
+	if !anyName(f.Region, r.Device.Geo.Region) || !anyName(f.City, r.Device.Geo.City) {
+		return false
+	}
+	if f.Blocklist.BlocksPage(page) {
+		return false
+	}
+	if _, ok := f.RangeMultiplier(r); !ok {
+		return false
+	}
+	return f.Targeting.Match(r, d)
+}
+
+// anyOf is true when the folder doesn't filter on a dimension, or when the value is one of those allowed.
+func anyOf(allowed []int, value int) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == value {
+			return true
+		}
+	}
+	return false
+}
+
+func anyName(allowed []string, value string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, value) {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *Folder) String() string {
-	dims := fmt.Sprintf(`ve %d, co %d, br %d, ne %d, su %d, nt %d, ge %d, de %d`, f.Vertical, f.Country, f.Brand, f.Network, f.SubNetwork, f.NetworkType, f.Gender, f.DeviceType)
+	dims := fmt.Sprintf(`ve %d, co %d, br %d, ne %d, su %d, nt %d, ge %d, de %d, sc %d, os %d, bw %d`, f.Vertical, f.Country, f.Brand, f.Network, f.SubNetwork, f.NetworkType, f.Gender, f.DeviceType, f.Subchannel, f.OS, f.Browser)
 	return fmt.Sprintf(`folder %d (child %d, cpc %d, #cr %d, dims %s)`, f.ID, len(f.Children), f.CPC, len(f.Creative), dims)
 }
 
@@ -671,6 +1003,9 @@ func (f *Folders) Unmarshal(depth int, env services.BindingDeps) error {
 		}
 	}
 
+	if err := f.Validate(); err != nil {
+		services.Important(err.Error())
+	}
 	env.Debug.Printf("LOADED %s %T %s", wide(depth), f, tojson(f))
 	return nil
 }
@@ -730,6 +1065,9 @@ func (c *Creatives) Unmarshal(depth int, env services.BindingDeps) error {
 		}
 	}
 
+	if err := c.Validate(); err != nil {
+		services.Important(err.Error())
+	}
 	env.Debug.Printf("LOADED %s %T %s", wide(depth), c, tojson(c))
 	return nil
 }
@@ -738,6 +1076,10 @@ type Creative struct {
 	ID          int
 	RedirectUrl string
 	Active      bool
+	Attributes  []string
+	Categories  []string
+	Domains     []string
+	Problems    []error
 }
 
 func (c *Creative) Unmarshal(depth int, env services.BindingDeps) error {
@@ -751,9 +1093,43 @@ func (c *Creative) Unmarshal(depth int, env services.BindingDeps) error {
 	if deleted_at.Valid {
 		c.Active = false
 	}
+	if err := ValidateMacros(c.RedirectUrl); err != nil {
+		env.Debug.Println("err", err)
+		c.Problems = append(c.Problems, err)
+		c.Active = false
+	}
+
+	for _, list := range []struct {
+		sql string
+		to  *[]string
+	}{{sqlCreativeAttributes, &c.Attributes}, {sqlCreativeCategories, &c.Categories}, {sqlCreativeDomains, &c.Domains}} {
+		// databases from before these tables still load, the creative just can't be checked
+		// against the request's blocks
+		rows, err := env.ConfigDB.Query(list.sql, c.ID)
+		if err != nil {
+			env.Debug.Println("err", err)
+			c.Problems = append(c.Problems, err)
+			continue
+		}
+		var value string
+		for rows.Next() {
+			if err := rows.Scan(&value); err != nil {
+				env.Debug.Println("err", err)
+				c.Problems = append(c.Problems, err)
+				break
+			}
+			*list.to = append(*list.to, value)
+		}
+		rows.Close()
+	}
 	return nil
 }
 
+// Expand is the creative's destination with its macros filled.
+func (c *Creative) Expand(v *MacroValues) string {
+	return ExpandURL(c.RedirectUrl, v)
+}
+
 func (c *Creative) String() string {
 	return fmt.Sprintf(`creative %d (%s)`, c.ID, c.RedirectUrl)
 }
@@ -774,6 +1150,20 @@ func (StatsDB) allowFailure(sql string, db *sql.DB) {
 func (s StatsDB) Marshal(db *sql.DB) error {
 	log.Println("creating purchases table")
 	s.allowFailure(sqlCreatePurchases, db)
+	s.allowFailure(sqlAddPurchasesSubchannel, db)
+	s.allowFailure(sqlAddPurchasesDeal, db)
+	s.allowFailure(sqlAddPurchasesPlacement, db)
+	log.Println("creating clicks table")
+	s.allowFailure(sqlCreateClicks, db)
+	s.allowFailure(sqlAddClicksClickID, db)
+	s.allowFailure(sqlAddClicksSSP, db)
+	s.allowFailure(sqlAddClicksFolder, db)
+	s.allowFailure(sqlAddClicksCreative, db)
+	log.Println("creating conversions table")
+	s.allowFailure(sqlCreateConversions, db)
+	log.Println("creating postback tables")
+	s.allowFailure(sqlCreatePostbacks, db)
+	s.allowFailure(sqlCreatePostbackLog, db)
 	return nil
 }
 
@@ -782,14 +1172,23 @@ type Recalls struct {
 }
 
 func (s Recalls) Save(f json.Marshaler, idLoc *int) error {
+	return s.SaveContext(context.Background(), f, idLoc)
+}
+
+// SaveContext is Save, giving up once ctx is done (eg when the bid's deadline passes).
+func (s Recalls) SaveContext(ctx context.Context, f json.Marshaler, idLoc *int) error {
 	js, _ := f.MarshalJSON()
 	var err error
-	*idLoc, err = s.Env.Redis.FindID(string(js))
+	*idLoc, err = s.Env.Redis.FindIDContext(ctx, string(js))
 	return err
 }
 
 func (s Recalls) Fetch(f json.Unmarshaler, recall string) error {
-	target, err := s.Env.Redis.Load(recall)
+	return s.FetchContext(context.Background(), f, recall)
+}
+
+func (s Recalls) FetchContext(ctx context.Context, f json.Unmarshaler, recall string) error {
+	target, err := s.Env.Redis.LoadContext(ctx, recall)
 	if err != nil {
 		return err
 	}
@@ -801,27 +1200,54 @@ func (s Recalls) Fetch(f json.Unmarshaler, recall string) error {
 	return nil
 }
 
+// Clicks records clicks in the stats db.
+type Clicks struct {
+	Env services.BindingDeps
+}
+
+func (s Clicks) Save(ctx context.Context, clickID string, t *ClickToken) error {
+	_, err := s.Env.StatsDB.ExecContext(ctx, sqlInsertClick, t.SaleID, clickID, t.SSPID, t.FolderID, t.CreativeID)
+	return err
+}
+
 type Purchases struct {
 	Env      services.BindingDeps
 	SkipWork bool
 }
 
 func (s Purchases) Save(fs [][5]interface{}, quit func(error) bool) {
+	rows := make([][]interface{}, len(fs))
+	for i := range fs {
+		rows[i] = fs[i][:]
+	}
+	s.insert(sqlInsertPurchases, rows, quit)
+}
+
+// SaveAll saves purchases with every column filled, see Revenue.Purchase.
+func (s Purchases) SaveAll(fs []*Purchase, quit func(error) bool) {
+	rows := make([][]interface{}, len(fs))
+	for i, p := range fs {
+		rows[i] = p.Values()
+	}
+	s.insert(sqlInsertPurchaseRows, rows, quit)
+}
+
+func (s Purchases) insert(insert string, rows [][]interface{}, quit func(error) bool) {
 	q := []string{}
 	args := []interface{}{}
 
 	n := 1
-	for _, f := range fs {
+	for _, f := range rows {
 		thisInsertString := []string{}
 		for range f {
 			thisInsertString = append(thisInsertString, fmt.Sprintf(`$%d`, n))
 			n++
 		}
 		q = append(q, "("+strings.Join(thisInsertString, ",")+")")
-		args = append(args, f[:]...)
+		args = append(args, f...)
 	}
 
-	query := sqlInsertPurchases + strings.Join(q, ",")
+	query := insert + strings.Join(q, ",")
 	s.Env.Logger.Println("query:", query)
 
 	for attempt := 15; attempt > 0; attempt-- {
@@ -839,6 +1265,7 @@ func (s Purchases) Save(fs [][5]interface{}, quit func(error) bool) {
 }
 
 const sqlInsertPurchases = `INSERT INTO purchases (sale_id, rev_tx, rev_tx_home, folder_id, creative_id) VALUES `
+const sqlInsertPurchaseRows = `INSERT INTO purchases (sale_id, billable, rev_tx, rev_tx_home, rev_ssp, rev_ssp_home, ssp_id, folder_id, creative_id, country_id, vertical_id, brand_id, network_id, subnetwork_id, networktype_id, gender_id, devicetype_id, subchannel_id, deal_id, placement) VALUES `
 
 const sqlCreatePurchases = `CREATE TABLE purchases (
 	created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
@@ -860,5 +1287,44 @@ const sqlCreatePurchases = `CREATE TABLE purchases (
 	subnetwork_id int NOT NULL,
 	networktype_id int NOT NULL,
 	gender_id int NOT NULL,
-	devicetype_id int NOT NULL
+	devicetype_id int NOT NULL,
+	subchannel_id int NOT NULL DEFAULT 0,
+	deal_id varchar(255) NOT NULL DEFAULT '',
+	placement varchar(255) NOT NULL DEFAULT ''
+);`
+
+const sqlAddPurchasesSubchannel = `ALTER TABLE purchases ADD COLUMN subchannel_id int NOT NULL DEFAULT 0`
+const sqlAddPurchasesDeal = `ALTER TABLE purchases ADD COLUMN deal_id varchar(255) NOT NULL DEFAULT ''`
+const sqlAddPurchasesPlacement = `ALTER TABLE purchases ADD COLUMN placement varchar(255) NOT NULL DEFAULT ''`
+
+const sqlCreateClicks = `CREATE TABLE clicks (
+	created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	sale_id int NOT NULL,
+	click_id varchar(64) NOT NULL DEFAULT '',
+	ssp_id int NOT NULL DEFAULT 0,
+	folder_id int NOT NULL DEFAULT 0,
+	creative_id int NOT NULL DEFAULT 0
+);`
+const sqlAddClicksClickID = `ALTER TABLE clicks ADD COLUMN click_id varchar(64) NOT NULL DEFAULT ''`
+const sqlAddClicksSSP = `ALTER TABLE clicks ADD COLUMN ssp_id int NOT NULL DEFAULT 0`
+const sqlAddClicksFolder = `ALTER TABLE clicks ADD COLUMN folder_id int NOT NULL DEFAULT 0`
+const sqlAddClicksCreative = `ALTER TABLE clicks ADD COLUMN creative_id int NOT NULL DEFAULT 0`
+const sqlCreatePostbacks = `CREATE TABLE postbacks (
+	id serial PRIMARY KEY,
+	created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	event varchar(32) NOT NULL,
+	url text NOT NULL,
+	attempts int NOT NULL DEFAULT 0,
+	next_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	finished_at timestamp NULL,
+	last_status int NOT NULL DEFAULT 0,
+	last_error text NOT NULL DEFAULT ''
+);`
+const sqlCreatePostbackLog = `CREATE TABLE postback_log (
+	created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	postback_id int NOT NULL,
+	attempt int NOT NULL,
+	status int NOT NULL,
+	error text NOT NULL DEFAULT ''
 );`
+const sqlInsertClick = `INSERT INTO clicks (sale_id, click_id, ssp_id, folder_id, creative_id) VALUES ($1, $2, $3, $4, $5)`
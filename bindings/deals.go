@@ -0,0 +1,49 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+)
+
+// DealMatch is a deal offered on an impression that the ssp has set up with us.
+type DealMatch struct {
+	Deal  *Deal
+	Offer *rtb_types.Deal
+}
+
+// MatchDeal picks the highest priority of the impression's deals that the ssp has set up with us.
+// The match is nil when there's no such deal, and ok is false if we can't bid at all, being a
+// private auction (PMP.ID, private_auction on the wire) we have no deal for.
+func (u *User) MatchDeal(imp *rtb_types.Impression) (m *DealMatch, ok bool) {
+	for _, offer := range imp.PMP.Deals {
+		if offer == nil {
+			continue
+		}
+		deal := u.Deals.ByID(offer.ID)
+		if deal == nil {
+			continue
+		}
+		if m == nil || deal.Priority > m.Deal.Priority {
+			m = &DealMatch{Deal: deal, Offer: offer}
+		}
+	}
+	if m == nil && imp.PMP.ID == 1 {
+		return nil, false
+	}
+	return m, true
+}
+
+// Floor is the lowest price the impression can be bought for and its currency, the deal's own
+// floor taking the place of the open auction's when it has one.
+func (m *DealMatch) Floor(imp *rtb_types.Impression) (int, string) {
+	if m != nil && m.Offer.BidFloor > 0 {
+		return m.Offer.BidFloor, m.Offer.BidFloorCur
+	}
+	return imp.BidFloor, imp.BidFloorCur
+}
+
+// Apply marks the bid as made under the deal.
+func (m *DealMatch) Apply(bid *rtb_types.Bid) {
+	if m != nil {
+		bid.DealID = m.Deal.ID
+	}
+}
@@ -0,0 +1,49 @@
+package bindings
+
+import (
+	"encoding/json"
+	"github.com/clixxa/dsp/rtb_types"
+	"testing"
+)
+
+func TestMatchDeal(t *testing.T) {
+	u := &User{ID: 1, Deals: Deals{{ID: "low", Priority: 1}, {ID: "high", Priority: 5}}}
+	imp := func(body string) *rtb_types.Impression {
+		i := &rtb_types.Impression{}
+		if err := json.Unmarshal([]byte(body), i); err != nil {
+			t.Fatal(err)
+		}
+		return i
+	}
+
+	cases := []struct {
+		body  string
+		deal  string
+		ok    bool
+		floor int
+	}{
+		{`{"bidfloor":100}`, "", true, 100},
+		{`{"bidfloor":100,"pmp":{"private_auction":1,"deals":[{"id":"other"}]}}`, "", false, 0},
+		{`{"bidfloor":100,"pmp":{"deals":[null,{"id":"low","bidfloor":300},{"id":"high"},{"id":"other"}]}}`, "high", true, 100},
+		{`{"bidfloor":100,"pmp":{"private_auction":1,"deals":[{"id":"low","bidfloor":300,"bidfloorcur":"EUR"}]}}`, "low", true, 300},
+	}
+	for i, c := range cases {
+		im := imp(c.body)
+		m, ok := u.MatchDeal(im)
+		if ok != c.ok {
+			t.Errorf(`case %d: ok %v`, i, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		bid := &rtb_types.Bid{}
+		m.Apply(bid)
+		if bid.DealID != c.deal {
+			t.Errorf(`case %d: matched %q want %q`, i, bid.DealID, c.deal)
+		}
+		if floor, _ := m.Floor(im); floor != c.floor {
+			t.Errorf(`case %d: floor %d want %d`, i, floor, c.floor)
+		}
+	}
+}
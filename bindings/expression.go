@@ -0,0 +1,473 @@
+package bindings
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/clixxa/dsp/rtb_types"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a compiled folder targeting rule, eg:
+//
+//	country in (US,CA) and (devicetype = mobile or sessiondepth > 3) and not network = 12
+//
+// Field names are the json paths of rtb_types.Request (site.network, device.geo.country,
+// user.sessiondepth, imp.bidfloor...), their short forms (network, country...) and the
+// rtb_types.Dimensions ids (networkid, countryid...). A field that has both a name and an
+// id compares against the id when given a number, so network = 12 checks Dimensions.NetworkID
+// while network = foo checks Site.Network.
+type Expression struct {
+	Source string
+	eval   func(*rtb_types.Request, *rtb_types.Dimensions) bool
+}
+
+func (e *Expression) Match(r *rtb_types.Request, d *rtb_types.Dimensions) bool {
+	if e == nil || e.eval == nil {
+		return true
+	}
+	return e.eval(r, d)
+}
+
+func (e *Expression) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Source)
+}
+
+func (e *Expression) String() string {
+	return e.Source
+}
+
+type ExprError struct {
+	Pos int
+	Msg string
+}
+
+func (e ExprError) Error() string {
+	return fmt.Sprintf(`column %d: %s`, e.Pos+1, e.Msg)
+}
+
+// CompileExpression parses and type checks src against the known request fields.
+func CompileExpression(src string) (*Expression, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	eval, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, ExprError{t.pos, fmt.Sprintf(`unexpected %q`, t.text)}
+	}
+	return &Expression{Source: src, eval: eval}, nil
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type exprToken struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var toks []exprToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			toks = append(toks, exprToken{tokLParen, "(", i})
+			i++
+		case c == ')':
+			toks = append(toks, exprToken{tokRParen, ")", i})
+			i++
+		case c == ',':
+			toks = append(toks, exprToken{tokComma, ",", i})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(src) && src[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, ExprError{i, `expected != but found !`}
+			}
+			if op == "==" {
+				op = "="
+			}
+			toks = append(toks, exprToken{tokOp, op, i})
+			i += len(op)
+			if op == "=" && i < len(src) && src[i] == '=' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], src[i])
+			if end < 0 {
+				return nil, ExprError{i, `unterminated string`}
+			}
+			toks = append(toks, exprToken{tokString, src[i+1 : i+1+end], i})
+			i += end + 2
+		case c == '-' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(src) && unicode.IsDigit(rune(src[j])) {
+				j++
+			}
+			if j == i+1 && c == '-' {
+				return nil, ExprError{i, `expected a number after -`}
+			}
+			toks = append(toks, exprToken{tokNumber, src[i:j], i})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] == '.' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, exprToken{tokIdent, src[i:j], i})
+			i = j
+		default:
+			return nil, ExprError{i, fmt.Sprintf(`unexpected character %q`, c)}
+		}
+	}
+	return append(toks, exprToken{tokEOF, "end of expression", len(src)}), nil
+}
+
+type evalFunc func(*rtb_types.Request, *rtb_types.Dimensions) bool
+
+type exprParser struct {
+	toks []exprToken
+	n    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.toks[p.n]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.toks[p.n]
+	if t.kind != tokEOF {
+		p.n++
+	}
+	return t
+}
+
+func (p *exprParser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, word) {
+		p.n++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) or() (evalFunc, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return l(r, d) || right(r, d) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) and() (evalFunc, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return l(r, d) && right(r, d) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) unary() (evalFunc, error) {
+	if p.keyword("not") {
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return !inner(r, d) }, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, ExprError{t.pos, fmt.Sprintf(`expected ) but found %q`, t.text)}
+		}
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (evalFunc, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, ExprError{t.pos, fmt.Sprintf(`expected a field name but found %q`, t.text)}
+	}
+	f, ok := exprFields[strings.ToLower(t.text)]
+	if !ok {
+		return nil, ExprError{t.pos, fmt.Sprintf(`unknown field %q`, t.text)}
+	}
+
+	negate := false
+	if p.keyword("not") {
+		negate = true
+		if o := p.peek(); o.kind != tokIdent || !strings.EqualFold(o.text, "in") {
+			return nil, ExprError{o.pos, fmt.Sprintf(`expected in after not but found %q`, o.text)}
+		}
+	}
+
+	var eval evalFunc
+	var err error
+	switch o := p.peek(); {
+	case p.keyword("in"):
+		eval, err = p.in(t, f)
+	case o.kind == tokOp:
+		p.next()
+		eval, err = p.compare(t, f, o)
+	default:
+		if f.flag == nil {
+			return nil, ExprError{o.pos, fmt.Sprintf(`%s is not a boolean, expected a comparison but found %q`, t.text, o.text)}
+		}
+		eval = func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return f.flag(r, d) }
+	}
+	if err != nil {
+		return nil, err
+	}
+	if negate {
+		inner := eval
+		eval = func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return !inner(r, d) }
+	}
+	return eval, nil
+}
+
+func (p *exprParser) value() (exprToken, error) {
+	t := p.next()
+	switch t.kind {
+	case tokIdent, tokNumber, tokString:
+		return t, nil
+	}
+	return t, ExprError{t.pos, fmt.Sprintf(`expected a value but found %q`, t.text)}
+}
+
+func (p *exprParser) compare(field exprToken, f exprField, op exprToken) (evalFunc, error) {
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	if v.kind == tokIdent && (strings.EqualFold(v.text, "true") || strings.EqualFold(v.text, "false")) && f.flag != nil {
+		want := strings.EqualFold(v.text, "true")
+		switch op.text {
+		case "=":
+			return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return f.flag(r, d) == want }, nil
+		case "!=":
+			return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return f.flag(r, d) != want }, nil
+		}
+		return nil, ExprError{op.pos, fmt.Sprintf(`%s is a boolean and can't use %s`, field.text, op.text)}
+	}
+
+	if v.kind == tokNumber && f.num != nil {
+		want, err := strconv.Atoi(v.text)
+		if err != nil {
+			return nil, ExprError{v.pos, fmt.Sprintf(`%s is out of range for %s`, v.text, field.text)}
+		}
+		get := f.num
+		switch op.text {
+		case "=":
+			return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return get(r, d) == want }, nil
+		case "!=":
+			return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return get(r, d) != want }, nil
+		case "<":
+			return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return get(r, d) < want }, nil
+		case "<=":
+			return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return get(r, d) <= want }, nil
+		case ">":
+			return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return get(r, d) > want }, nil
+		case ">=":
+			return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return get(r, d) >= want }, nil
+		}
+	}
+
+	if f.str == nil && f.list == nil {
+		return nil, ExprError{v.pos, fmt.Sprintf(`%s can't be compared with %q`, field.text, v.text)}
+	}
+	if op.text != "=" && op.text != "!=" {
+		return nil, ExprError{op.pos, fmt.Sprintf(`%s is text and can only use = or !=, not %s`, field.text, op.text)}
+	}
+	match := f.matcher([]string{v.text})
+	if op.text == "!=" {
+		return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return !match(r, d) }, nil
+	}
+	return match, nil
+}
+
+func (p *exprParser) in(field exprToken, f exprField) (evalFunc, error) {
+	if t := p.next(); t.kind != tokLParen {
+		return nil, ExprError{t.pos, fmt.Sprintf(`expected ( after in but found %q`, t.text)}
+	}
+	var vals []exprToken
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+		t := p.next()
+		if t.kind == tokRParen {
+			break
+		}
+		if t.kind != tokComma {
+			return nil, ExprError{t.pos, fmt.Sprintf(`expected , or ) but found %q`, t.text)}
+		}
+	}
+
+	numeric := f.num != nil
+	for _, v := range vals {
+		if v.kind != tokNumber {
+			numeric = false
+		}
+	}
+	if numeric {
+		want := make(map[int]bool, len(vals))
+		for _, v := range vals {
+			n, err := strconv.Atoi(v.text)
+			if err != nil {
+				return nil, ExprError{v.pos, fmt.Sprintf(`%s is out of range for %s`, v.text, field.text)}
+			}
+			want[n] = true
+		}
+		get := f.num
+		return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return want[get(r, d)] }, nil
+	}
+
+	if f.str == nil && f.list == nil {
+		if f.num != nil {
+			return nil, ExprError{field.pos, fmt.Sprintf(`%s only takes numbers`, field.text)}
+		}
+		return nil, ExprError{field.pos, fmt.Sprintf(`%s can't be used with in`, field.text)}
+	}
+	texts := make([]string, len(vals))
+	for i, v := range vals {
+		texts[i] = v.text
+	}
+	return f.matcher(texts), nil
+}
+
+type exprField struct {
+	str  func(*rtb_types.Request, *rtb_types.Dimensions) string
+	list func(*rtb_types.Request, *rtb_types.Dimensions) []string
+	num  func(*rtb_types.Request, *rtb_types.Dimensions) int
+	flag func(*rtb_types.Request, *rtb_types.Dimensions) bool
+}
+
+// matcher is true when the text field (or any item of a list field) equals one of vals, ignoring case.
+func (f exprField) matcher(vals []string) evalFunc {
+	want := make(map[string]bool, len(vals))
+	for _, v := range vals {
+		want[strings.ToLower(v)] = true
+	}
+	if f.list != nil {
+		return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool {
+			for _, s := range f.list(r, d) {
+				if want[strings.ToLower(s)] {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return func(r *rtb_types.Request, d *rtb_types.Dimensions) bool { return want[strings.ToLower(f.str(r, d))] }
+}
+
+type rq = rtb_types.Request
+type dm = rtb_types.Dimensions
+
+var exprFields = map[string]exprField{}
+
+func addExprField(f exprField, names ...string) {
+	for _, n := range names {
+		exprFields[n] = f
+	}
+}
+
+func init() {
+	addExprField(exprField{num: func(r *rq, d *dm) int { return r.Random255 }}, "rand")
+	addExprField(exprField{flag: func(r *rq, d *dm) bool { return r.Test }}, "test")
+
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.FirstImpression().ID }}, "imp.id", "impid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return r.FirstImpression().HourCount }}, "imp.hourcount", "hourcount")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return r.FirstImpression().BidFloor }}, "imp.bidfloor", "bidfloor")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return r.FirstImpression().PMP.ID }}, "imp.pmp.private_auction", "private_auction")
+	addExprField(exprField{list: func(r *rq, d *dm) []string { return r.FirstImpression().Redirect.BannedAttributes }}, "imp.redirect.battr", "battr")
+
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Site.Placement }}, "site.placement", "placement")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Site.Vertical }, num: func(r *rq, d *dm) int { return d.VerticalID }}, "site.vertical", "vertical")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Site.Brand }, num: func(r *rq, d *dm) int { return d.BrandID }}, "site.brand", "brand")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Site.Network }, num: func(r *rq, d *dm) int { return d.NetworkID }}, "site.network", "network")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Site.SubNetwork }, num: func(r *rq, d *dm) int { return d.SubNetworkID }}, "site.subnetwork", "subnetwork")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Site.Subchannel }, num: func(r *rq, d *dm) int { return d.SubchannelID }}, "site.subchannel", "subchannel")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Site.NetworkType }, num: func(r *rq, d *dm) int { return d.NetworkTypeID }}, "site.networktype", "networktype")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Site.Angle }, num: func(r *rq, d *dm) int { return d.AngleID }}, "site.angle", "angle")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return r.Site.Depth }}, "site.depth", "depth")
+	addExprField(exprField{list: func(r *rq, d *dm) []string { return r.Site.Keywords }}, "site.keywords", "keywords")
+
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Device.UserAgent }}, "device.ua", "ua")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Device.DeviceType }, num: func(r *rq, d *dm) int { return d.DeviceTypeID }}, "device.devicetype", "devicetype")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Device.OS }, num: func(r *rq, d *dm) int { return d.OSID }}, "device.os", "os")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Device.OSVersion }}, "device.osv", "osv")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Device.Browser }, num: func(r *rq, d *dm) int { return d.BrowserID }}, "device.browser", "browser")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Device.Geo.Country }, num: func(r *rq, d *dm) int { return d.CountryID }}, "device.geo.country", "country")
+
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Device.Geo.Region }}, "device.geo.region", "region")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.Device.Geo.City }}, "device.geo.city", "city")
+
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.User.Gender }, num: func(r *rq, d *dm) int { return d.GenderID }}, "user.gender", "gender")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.User.RemoteAddr }}, "user.remoteaddr", "remoteaddr")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.User.MostUniqueID }}, "user.muid", "muid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return r.User.SessionDepth }}, "user.sessiondepth", "sessiondepth")
+	addExprField(exprField{str: func(r *rq, d *dm) string { return r.User.Interest }, num: func(r *rq, d *dm) int { return d.InterestID }}, "user.interest", "interest")
+
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.VerticalID }}, "verticalid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.BrandID }}, "brandid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.NetworkID }}, "networkid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.SubNetworkID }}, "subnetworkid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.NetworkTypeID }}, "networktypeid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.DeviceTypeID }}, "devicetypeid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.CountryID }}, "countryid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.GenderID }}, "genderid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.InterestID }}, "interestid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.AngleID }}, "angleid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.SubchannelID }}, "subchannelid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.OSID }}, "osid")
+	addExprField(exprField{num: func(r *rq, d *dm) int { return d.BrowserID }}, "browserid")
+}
@@ -0,0 +1,56 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"testing"
+)
+
+func TestExpression(t *testing.T) {
+	r := &rtb_types.Request{}
+	r.Device.Geo.Country = "CA"
+	r.Device.DeviceType = "desktop"
+	r.User.SessionDepth = 5
+	r.Site.Keywords = []string{"cars", "loans"}
+	d := &rtb_types.Dimensions{NetworkID: 12}
+
+	cases := map[string]bool{
+		`country in (US,CA) and (devicetype = mobile or sessiondepth > 3) and not network = 12`: false,
+		`country in (US,CA) and (devicetype = mobile or sessiondepth > 3) and not network = 13`: true,
+		`country not in (us, ca)`:                     false,
+		`keywords = loans and networkid >= 12`:        true,
+		`device.geo.country = "CA" and test = false`:  true,
+		`test or user.sessiondepth<=4`:                false,
+		`network in (11, 13) or devicetype != mobile`: true,
+	}
+	for src, want := range cases {
+		e, err := CompileExpression(src)
+		if err != nil {
+			t.Errorf(`%s: %s`, src, err)
+			continue
+		}
+		if got := e.Match(r, d); got != want {
+			t.Errorf(`%s: got %v want %v`, src, got, want)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	for _, src := range []string{
+		`country in (US,CA`,
+		`colour = red`,
+		`gender > male`,
+		`sessiondepth = deep`,
+		`country = US and`,
+		`country`,
+		`test in (1, 2)`,
+		`country = 'US`,
+		`sessiondepth > 99999999999999999999`,
+		`sessiondepth in (1, -99999999999999999999)`,
+	} {
+		if _, err := CompileExpression(src); err == nil {
+			t.Errorf(`%s: expected an error`, src)
+		} else {
+			t.Logf(`%s: %s`, src, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package bindings
+
+import (
+	"fmt"
+	"github.com/clixxa/dsp/rtb_types"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Macros are the placeholders a creative's destination url may use, expanded at bid time.
+var Macros = map[string]func(v *MacroValues) string{
+	"clickid":    func(v *MacroValues) string { return v.ClickID },
+	"sspid":      func(v *MacroValues) string { return strconv.Itoa(v.SSPID) },
+	"folderid":   func(v *MacroValues) string { return strconv.Itoa(v.FolderID) },
+	"creativeid": func(v *MacroValues) string { return strconv.Itoa(v.CreativeID) },
+	"keyword":    func(v *MacroValues) string { return v.Keyword },
+	"country":    func(v *MacroValues) string { return v.Country },
+	"placement":  func(v *MacroValues) string { return v.Placement },
+	"price":      func(v *MacroValues) string { return strconv.FormatFloat(rtb_types.RPM(v.Price), 'f', -1, 64) },
+}
+
+// MacroValues is what the macros expand to for a bid. Price is in wire units and expands to the rpm.
+type MacroValues struct {
+	ClickID    string
+	SSPID      int
+	FolderID   int
+	CreativeID int
+	Keyword    string
+	Country    string
+	Placement  string
+	Price      float64
+}
+
+// macroAt finds the next {name} in s from start, returning its bounds, or -1 if there isn't one.
+func macroAt(s string, start int) (open, close int, err error) {
+	open = strings.IndexByte(s[start:], '{')
+	if open < 0 {
+		if strings.IndexByte(s[start:], '}') >= 0 {
+			return -1, -1, fmt.Errorf(`unmatched } in %q`, s)
+		}
+		return -1, -1, nil
+	}
+	open += start
+	close = strings.IndexByte(s[open:], '}')
+	if close < 0 {
+		return -1, -1, fmt.Errorf(`unterminated macro in %q`, s)
+	}
+	return open, open + close, nil
+}
+
+// ValidateMacros checks that every macro in a destination url is one of Macros.
+func ValidateMacros(dest string) error {
+	for at := 0; ; {
+		open, close, err := macroAt(dest, at)
+		if err != nil {
+			return err
+		} else if open < 0 {
+			return nil
+		}
+		if _, ok := Macros[dest[open+1:close]]; !ok {
+			return fmt.Errorf(`unknown macro %s in %q`, dest[open:close+1], dest)
+		}
+		at = close + 1
+	}
+}
+
+// ExpandURL fills the macros in a destination url, escaping each value for the part of the url it's
+// in. Unknown macros are left as they are, ValidateMacros keeps them out of loaded creatives.
+func ExpandURL(dest string, v *MacroValues) string {
+	var out strings.Builder
+	query := strings.IndexAny(dest, "?#")
+	at := 0
+	for {
+		open, close, err := macroAt(dest, at)
+		if err != nil || open < 0 {
+			break
+		}
+		out.WriteString(dest[at:open])
+		at = close + 1
+		value, ok := Macros[dest[open+1:close]]
+		if !ok {
+			out.WriteString(dest[open:at])
+			continue
+		}
+		if query >= 0 && open > query {
+			out.WriteString(url.QueryEscape(value(v)))
+		} else {
+			out.WriteString(url.PathEscape(value(v)))
+		}
+	}
+	out.WriteString(dest[at:])
+	return out.String()
+}
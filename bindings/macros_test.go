@@ -0,0 +1,35 @@
+package bindings
+
+import (
+	"testing"
+)
+
+func TestValidateMacros(t *testing.T) {
+	for dest, ok := range map[string]bool{
+		"http://example.com/":                           true,
+		"http://example.com/{folderid}?c={clickid}":     true,
+		"http://example.com/?kw={keyword}&p={price}":    true,
+		"http://example.com/?x={nope}":                  false,
+		"http://example.com/?x={clickid":                false,
+		"http://example.com/?x=clickid}":                false,
+		"http://example.com/?a={country}&b={placement}": true,
+	} {
+		if err := ValidateMacros(dest); (err == nil) != ok {
+			t.Errorf(`%s: expected ok %v, have %v`, dest, ok, err)
+		}
+	}
+}
+
+func TestExpandURL(t *testing.T) {
+	v := &MacroValues{ClickID: "abc", SSPID: 3, FolderID: 7, CreativeID: 9, Keyword: "red cars&more", Country: "US", Placement: "http://site.com/a?b=c", Price: 1500}
+	for dest, want := range map[string]string{
+		"http://example.com/{folderid}/{creativeid}?c={clickid}&s={sspid}": "http://example.com/7/9?c=abc&s=3",
+		"http://example.com/?kw={keyword}&p={price}&co={country}":          "http://example.com/?kw=red+cars%26more&p=1.5&co=US",
+		"http://example.com/{keyword}?pl={placement}":                      "http://example.com/red%20cars&more?pl=http%3A%2F%2Fsite.com%2Fa%3Fb%3Dc",
+		"http://example.com/#{keyword}":                                    "http://example.com/#red+cars%26more",
+	} {
+		if have := ExpandURL(dest, v); have != want {
+			t.Errorf(`%s: expected %s, have %s`, dest, want, have)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package bindings
+
+import (
+	"fmt"
+	"github.com/clixxa/dsp/rtb_types"
+	"strings"
+	"time"
+)
+
+// dimensionValues reads the value a bid modifier is keyed on, by the same type names as Dimension.Transfer.
+var dimensionValues = map[string]func(*rtb_types.Dimensions, time.Time) int{
+	`Vertical`:        func(d *rtb_types.Dimensions, t time.Time) int { return d.VerticalID },
+	`Country`:         func(d *rtb_types.Dimensions, t time.Time) int { return d.CountryID },
+	`Brand`:           func(d *rtb_types.Dimensions, t time.Time) int { return d.BrandID },
+	`Network`:         func(d *rtb_types.Dimensions, t time.Time) int { return d.NetworkID },
+	`SubNetwork`:      func(d *rtb_types.Dimensions, t time.Time) int { return d.SubNetworkID },
+	`NetworkType`:     func(d *rtb_types.Dimensions, t time.Time) int { return d.NetworkTypeID },
+	`Gender`:          func(d *rtb_types.Dimensions, t time.Time) int { return d.GenderID },
+	`DeviceType`:      func(d *rtb_types.Dimensions, t time.Time) int { return d.DeviceTypeID },
+	`Angle`:           func(d *rtb_types.Dimensions, t time.Time) int { return d.AngleID },
+	`CurrentInterest`: func(d *rtb_types.Dimensions, t time.Time) int { return d.InterestID },
+	`Subchannel`:      func(d *rtb_types.Dimensions, t time.Time) int { return d.SubchannelID },
+	`OS`:              func(d *rtb_types.Dimensions, t time.Time) int { return d.OSID },
+	`Browser`:         func(d *rtb_types.Dimensions, t time.Time) int { return d.BrowserID },
+	`HourOfDay`:       func(d *rtb_types.Dimensions, t time.Time) int { return t.UTC().Hour() },
+}
+
+// BidModifier scales a folder's bid when a dimension of the request has a given value.
+type BidModifier struct {
+	Type       string
+	Value      int
+	Multiplier float64
+}
+
+func (m *BidModifier) Check() error {
+	parts := strings.Split(m.Type, `\`)
+	m.Type = parts[len(parts)-1]
+	if _, ok := dimensionValues[m.Type]; !ok {
+		return fmt.Errorf(`bid modifier on unknown type: %s`, m.Type)
+	}
+	if m.Multiplier < 0 {
+		return fmt.Errorf(`bid modifier on %s %d has negative multiplier %f`, m.Type, m.Value, m.Multiplier)
+	}
+	return nil
+}
+
+type PriceStep struct {
+	Name   string
+	Factor float64
+}
+
+// PriceBreakdown records how a folder's bid was reached, so it can be logged with the bid.
+type PriceBreakdown struct {
+	Base  int
+	Steps []PriceStep
+	Final float64
+}
+
+func (p *PriceBreakdown) Apply(name string, factor float64) {
+	p.Steps = append(p.Steps, PriceStep{name, factor})
+	p.Final *= factor
+}
+
+func (p *PriceBreakdown) String() string {
+	str := []string{fmt.Sprintf(`cpc %d`, p.Base)}
+	for _, s := range p.Steps {
+		str = append(str, fmt.Sprintf(`%s %.3f`, s.Name, s.Factor))
+	}
+	return fmt.Sprintf(`%s = %.3f`, strings.Join(str, ` x `), p.Final)
+}
+
+// Price is the folder's bid for a request, after range multipliers and bid modifiers. The
+// breakdown is nil when the request is outside the folder's ranges.
+func (f *Folder) Price(r *rtb_types.Request, d *rtb_types.Dimensions, at time.Time) *PriceBreakdown {
+	ranges, ok := f.matchedRanges(r)
+	if !ok {
+		return nil
+	}
+	p := &PriceBreakdown{Base: f.CPC, Final: float64(f.CPC)}
+	for _, rg := range ranges {
+		p.Apply(rg.Field, rg.Multiplier)
+	}
+	for _, m := range f.Modifiers {
+		if dimensionValues[m.Type](d, at) == m.Value {
+			p.Apply(fmt.Sprintf(`%s %d`, m.Type, m.Value), m.Multiplier)
+		}
+	}
+	return p
+}
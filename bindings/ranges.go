@@ -0,0 +1,84 @@
+package bindings
+
+import (
+	"fmt"
+	"github.com/clixxa/dsp/rtb_types"
+)
+
+// Range is a band of a numeric request field. A folder with ranges on a field only bids when the
+// request falls inside one of them, and scales its bid by that range's multiplier.
+type Range struct {
+	Field      string
+	Min        *int
+	Max        *int
+	Multiplier float64
+}
+
+var rangeFields = map[string]func(*rtb_types.Request) int{
+	"sessiondepth": func(r *rtb_types.Request) int { return r.User.SessionDepth },
+	"depth":        func(r *rtb_types.Request) int { return r.Site.Depth },
+	"hourcount":    func(r *rtb_types.Request) int { return r.FirstImpression().HourCount },
+}
+
+func (rg *Range) Check() error {
+	if _, ok := rangeFields[rg.Field]; !ok {
+		return fmt.Errorf(`range on unknown field %q`, rg.Field)
+	}
+	if rg.Min != nil && rg.Max != nil && *rg.Min > *rg.Max {
+		return fmt.Errorf(`range on %s has min %d above max %d`, rg.Field, *rg.Min, *rg.Max)
+	}
+	if rg.Multiplier < 0 {
+		return fmt.Errorf(`range on %s has negative multiplier %f`, rg.Field, rg.Multiplier)
+	}
+	return nil
+}
+
+func (rg *Range) Contains(r *rtb_types.Request) bool {
+	v := rangeFields[rg.Field](r)
+	if rg.Min != nil && v < *rg.Min {
+		return false
+	}
+	if rg.Max != nil && v > *rg.Max {
+		return false
+	}
+	return true
+}
+
+// RangeMultiplier returns the product of the multipliers of the ranges the request falls in, or
+// false if the folder has ranges on a field and the request is outside all of them.
+func (f *Folder) RangeMultiplier(r *rtb_types.Request) (float64, bool) {
+	ranges, ok := f.matchedRanges(r)
+	if !ok {
+		return 0, false
+	}
+	mult := 1.0
+	for _, rg := range ranges {
+		mult *= rg.Multiplier
+	}
+	return mult, true
+}
+
+// matchedRanges is the first range the request falls in for each field the folder has ranges on,
+// in the folder's order so the multipliers always apply in the same order.
+func (f *Folder) matchedRanges(r *rtb_types.Request) ([]*Range, bool) {
+	var matches []*Range
+	matched := map[string]bool{}
+	for _, rg := range f.Ranges {
+		if _, known := rangeFields[rg.Field]; !known {
+			continue
+		}
+		if _, done := matched[rg.Field]; !done {
+			matched[rg.Field] = false
+		}
+		if !matched[rg.Field] && rg.Contains(r) {
+			matches = append(matches, rg)
+			matched[rg.Field] = true
+		}
+	}
+	for _, ok := range matched {
+		if !ok {
+			return nil, false
+		}
+	}
+	return matches, true
+}
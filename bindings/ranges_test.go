@@ -0,0 +1,57 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"testing"
+	"time"
+)
+
+func TestRangeMultiplier(t *testing.T) {
+	one, three, five := 1, 3, 5
+	f := &Folder{Ranges: []*Range{
+		{Field: "sessiondepth", Max: &one, Multiplier: 0.5},
+		{Field: "sessiondepth", Min: &three, Max: &five, Multiplier: 2},
+		{Field: "depth", Min: &one, Multiplier: 1.5},
+		{Field: "unknown", Multiplier: 10},
+	}}
+	for _, tc := range []struct {
+		session, depth int
+		mult           float64
+		ok             bool
+	}{
+		{0, 1, 0.75, true},
+		{4, 2, 3, true},
+		{2, 1, 0, false},
+		{4, 0, 0, false},
+	} {
+		r := &rtb_types.Request{}
+		r.User.SessionDepth, r.Site.Depth = tc.session, tc.depth
+		if mult, ok := f.RangeMultiplier(r); mult != tc.mult || ok != tc.ok {
+			t.Errorf(`session %d depth %d: got %f %v want %f %v`, tc.session, tc.depth, mult, ok, tc.mult, tc.ok)
+		}
+	}
+
+	if mult, ok := (&Folder{}).RangeMultiplier(&rtb_types.Request{}); mult != 1 || !ok {
+		t.Error("a folder without ranges should bid unchanged")
+	}
+}
+
+func TestRangeOrder(t *testing.T) {
+	one := 1
+	f := &Folder{CPC: 100, Ranges: []*Range{
+		{Field: "hourcount", Multiplier: 1.1},
+		{Field: "depth", Min: &one, Multiplier: 1.3},
+		{Field: "sessiondepth", Multiplier: 0.7},
+	}}
+	r := &rtb_types.Request{}
+	r.Site.Depth = 1
+	want := f.Price(r, &rtb_types.Dimensions{}, time.Time{}).String()
+	for i := 0; i < 20; i++ {
+		if got := f.Price(r, &rtb_types.Dimensions{}, time.Time{}).String(); got != want {
+			t.Fatalf(`breakdown changed from %s to %s`, want, got)
+		}
+	}
+	if want != "cpc 100 x hourcount 1.100 x depth 1.300 x sessiondepth 0.700 = 100.100" {
+		t.Errorf(`steps aren't in the folder's order: %s`, want)
+	}
+}
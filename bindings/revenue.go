@@ -0,0 +1,98 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"math"
+)
+
+// Split is how a won auction's money divides between the advertiser's spend (Tx) and what we owe
+// the ssp (SSP), in the ssp's currency and in the home currency. The difference is our margin.
+type Split struct {
+	Tx      int
+	TxHome  int
+	SSP     int
+	SSPHome int
+}
+
+// Revenue works out the Split of each win, and is the only place that should.
+type Revenue struct {
+	FX       *FXRates
+	Counters *services.Counters
+}
+
+// Split takes the winning price (AUCTION_PRICE, in the ssp's currency), all of which the
+// advertiser is charged. The ssp is owed the deal's FixedCPC (in the ssp's currency) if it has one,
+// else the deal's FixedRevShare of the price, else the price less the ssp's Margin. The win
+// happened either way, so a share outside 0 to the price is capped, and counted as share_capped.
+func (rv Revenue) Split(price float64, ssp *User, deal *Deal) (Split, error) {
+	share := price * (1 - ssp.Margin)
+	if deal != nil {
+		if deal.FixedCPC > 0 {
+			share = float64(deal.FixedCPC)
+		} else if deal.FixedRevShare > 0 {
+			share = price * deal.FixedRevShare
+		}
+	}
+	if share < 0 || share > price {
+		rv.Counters.Inc(ssp.ID, "share_capped")
+		share = math.Max(0, math.Min(share, price))
+	}
+
+	priceHome, err := rv.FX.ToHome(price, ssp.Currency)
+	if err != nil {
+		return Split{}, err
+	}
+	shareHome, err := rv.FX.ToHome(share, ssp.Currency)
+	if err != nil {
+		return Split{}, err
+	}
+	return Split{
+		Tx:      int(math.Round(price)),
+		TxHome:  int(math.Round(priceHome)),
+		SSP:     int(math.Round(share)),
+		SSPHome: int(math.Round(shareHome)),
+	}, nil
+}
+
+// Purchase is a row of the purchases table, one per won auction.
+type Purchase struct {
+	SaleID     int
+	Billable   bool
+	Split      Split
+	SSPID      int
+	FolderID   int
+	CreativeID int
+	DealID     string
+	Placement  string
+	Dimensions rtb_types.Dimensions
+}
+
+// Purchase builds the purchases row for a win. Wins that aren't billable (test traffic) are
+// recorded with no money attached.
+func (rv Revenue) Purchase(saleID int, billable bool, price float64, ssp *User, deal *Deal, folderID, creativeID int, placement string, dims rtb_types.Dimensions) (*Purchase, error) {
+	p := &Purchase{SaleID: saleID, Billable: billable, SSPID: ssp.ID, FolderID: folderID, CreativeID: creativeID, Placement: placement, Dimensions: dims}
+	if deal != nil {
+		p.DealID = deal.ID
+	}
+	if billable {
+		split, err := rv.Split(price, ssp, deal)
+		if err != nil {
+			return nil, err
+		}
+		p.Split = split
+	}
+	return p, nil
+}
+
+// Values are in the column order of sqlInsertPurchaseRows.
+func (p *Purchase) Values() []interface{} {
+	d := p.Dimensions
+	return []interface{}{
+		p.SaleID, p.Billable,
+		p.Split.Tx, p.Split.TxHome, p.Split.SSP, p.Split.SSPHome,
+		p.SSPID, p.FolderID, p.CreativeID,
+		d.CountryID, d.VerticalID, d.BrandID, d.NetworkID, d.SubNetworkID, d.NetworkTypeID, d.GenderID, d.DeviceTypeID, d.SubchannelID,
+		p.DealID, p.Placement,
+	}
+}
@@ -0,0 +1,64 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"strings"
+	"testing"
+)
+
+func TestRevenueSplit(t *testing.T) {
+	rv := Revenue{FX: &FXRates{Home: "USD", Rates: map[string]float64{"USD": 1, "EUR": 0.8}}}
+	usd := &User{ID: 1, Margin: 0.25}
+	eur := &User{ID: 2, Currency: "EUR", Margin: 0.1}
+
+	cases := []struct {
+		price float64
+		ssp   *User
+		deal  *Deal
+		want  Split
+	}{
+		{1000, usd, nil, Split{1000, 1000, 750, 750}},
+		{1000, usd, &Deal{FixedRevShare: 0.6}, Split{1000, 1000, 600, 600}},
+		{1000, usd, &Deal{FixedCPC: 300, FixedRevShare: 0.6}, Split{1000, 1000, 300, 300}},
+		{800, eur, nil, Split{800, 1000, 720, 900}},
+		{800, eur, &Deal{}, Split{800, 1000, 720, 900}},
+	}
+	for i, c := range cases {
+		got, err := rv.Split(c.price, c.ssp, c.deal)
+		if err != nil {
+			t.Errorf(`case %d: %s`, i, err)
+		} else if got != c.want {
+			t.Errorf(`case %d: got %+v want %+v`, i, got, c.want)
+		}
+	}
+
+	rv.Counters = &services.Counters{}
+	if got, err := rv.Split(100, usd, &Deal{FixedCPC: 300}); err != nil || got != (Split{100, 100, 100, 100}) {
+		t.Errorf(`expected a fixed cpc above the price to be capped, got %+v %v`, got, err)
+	}
+	if rv.Counters.Get(usd.ID, "share_capped") != 1 {
+		t.Error("capped share wasn't counted")
+	}
+	if _, err := rv.Split(100, &User{Currency: "GBP"}, nil); err == nil {
+		t.Error("expected an unknown currency to be refused")
+	}
+}
+
+func TestPurchaseValues(t *testing.T) {
+	rv := Revenue{FX: &FXRates{}}
+	p, err := rv.Purchase(7, false, 1000, &User{ID: 3}, &Deal{ID: "d1"}, 4, 5, "http://site.com/a", rtb_types.Dimensions{CountryID: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Placement != "http://site.com/a" {
+		t.Errorf(`placement %q wasn't kept`, p.Placement)
+	}
+	if p.Split != (Split{}) {
+		t.Errorf(`unbillable purchase has money: %+v`, p.Split)
+	}
+	cols := strings.Split(sqlInsertPurchaseRows[strings.Index(sqlInsertPurchaseRows, "(")+1:strings.Index(sqlInsertPurchaseRows, ")")], ",")
+	if vals := p.Values(); len(vals) != len(cols) {
+		t.Errorf(`%d values for %d columns`, len(vals), len(cols))
+	}
+}
@@ -0,0 +1,216 @@
+package bindings
+
+import (
+	"fmt"
+	"github.com/clixxa/dsp/services"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// Creative rotation modes, folder_settings 3. Weighted is the default.
+const (
+	RotateWeighted = "weighted"
+	RotateThompson = "thompson"
+)
+
+// a sale succeeded once, however many clicks and conversions it had
+const sqlCreativeFeedback = `SELECT p.folder_id, p.creative_id, COUNT(*), SUM(CASE WHEN EXISTS (SELECT 1 FROM clicks c WHERE c.sale_id = p.sale_id) OR EXISTS (SELECT 1 FROM conversions v WHERE v.sale_id = p.sale_id) THEN 1 ELSE 0 END) FROM purchases p WHERE p.created_at > NOW() - INTERVAL '%d DAY' GROUP BY p.folder_id, p.creative_id`
+
+type rotationKey struct {
+	FolderID   int
+	CreativeID int
+}
+
+// Feedback is how often a creative in a folder was shown and how often that succeeded (clicked or converted).
+type Feedback struct {
+	Shown     float64
+	Succeeded float64
+}
+
+// Rotation picks which of a folder's creatives to show. Weighted folders pick in proportion to
+// the creative weights, thompson folders sample each creative's success rate from
+// Beta(successes+1, failures+1) and pick the highest, using the last Days of purchases and clicks
+// plus whatever's been recorded since, reloaded each cycle.
+type Rotation struct {
+	BindingDeps services.BindingDeps
+	Days        int
+
+	lock     sync.Mutex
+	feedback map[rotationKey]*Feedback
+	rnd      *rand.Rand
+}
+
+func (r *Rotation) init() {
+	if r.feedback == nil {
+		r.feedback = make(map[rotationKey]*Feedback)
+	}
+	if r.rnd == nil {
+		r.rnd = rand.New(rand.NewSource(rand.Int63()))
+	}
+}
+
+func (r *Rotation) Cycle(quit func(error) bool) {
+	if r.BindingDeps.StatsDB == nil {
+		return
+	}
+	err := r.Unmarshal(0, r.BindingDeps)
+	quit(services.ErrDatabaseMissing{Name: "creative feedback", UnderlyingErr: err})
+}
+
+func (r *Rotation) Unmarshal(depth int, env services.BindingDeps) error {
+	if r.Days == 0 {
+		r.Days = 7
+	}
+	rows, err := env.StatsDB.Query(fmt.Sprintf(sqlCreativeFeedback, r.Days))
+	if err != nil {
+		env.Debug.Println("err", err)
+		return err
+	}
+	feedback := make(map[rotationKey]*Feedback)
+	for rows.Next() {
+		var k rotationKey
+		fb := &Feedback{}
+		if err := rows.Scan(&k.FolderID, &k.CreativeID, &fb.Shown, &fb.Succeeded); err != nil {
+			env.Debug.Println("err", err)
+			return err
+		}
+		feedback[k] = fb
+	}
+
+	r.lock.Lock()
+	r.feedback = feedback
+	r.lock.Unlock()
+	env.Debug.Printf("LOADED %s %T %d creatives", wide(depth), r, len(feedback))
+	return nil
+}
+
+// Shown records an impression of the creative.
+func (r *Rotation) Shown(folderID, creativeID int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.init()
+	r.at(folderID, creativeID).Shown++
+}
+
+// Succeeded records a click or conversion on the creative.
+func (r *Rotation) Succeeded(folderID, creativeID int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.init()
+	r.at(folderID, creativeID).Succeeded++
+}
+
+// Converted is a ConversionPostback hook. A conversion through one of our clicks was already
+// counted a success when clicked, so only those without a click count.
+func (r *Rotation) Converted(c *Conversion) {
+	if c.ClickID == "" {
+		r.Succeeded(c.FolderID, c.CreativeID)
+	}
+}
+
+func (r *Rotation) at(folderID, creativeID int) *Feedback {
+	k := rotationKey{folderID, creativeID}
+	if r.feedback[k] == nil {
+		r.feedback[k] = &Feedback{}
+	}
+	return r.feedback[k]
+}
+
+// Pick returns the creative to show for the folder, false when it has none to show.
+func (r *Rotation) Pick(f *Folder) (int, bool) {
+	return r.PickFrom(f, f.Creative)
+}
+
+// PickFrom is Pick limited to the eligible creatives, see Creatives.Eligible.
+func (r *Rotation) PickFrom(f *Folder, eligible []int) (int, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.init()
+	allowed := make(map[int]bool, len(eligible))
+	for _, id := range eligible {
+		allowed[id] = true
+	}
+	weight := func(pos int) float64 {
+		if !allowed[f.Creative[pos]] {
+			return 0
+		}
+		return f.weight(pos)
+	}
+	if len(eligible) == 0 {
+		return 0, false
+	}
+	if f.Rotation == RotateThompson {
+		best, bestSample := -1, -1.0
+		for pos, id := range f.Creative {
+			if weight(pos) <= 0 {
+				continue
+			}
+			var fb Feedback
+			if at := r.feedback[rotationKey{f.ID, id}]; at != nil {
+				fb = *at
+			}
+			failed := math.Max(fb.Shown-fb.Succeeded, 0)
+			if sample := betaSample(r.rnd, fb.Succeeded+1, failed+1); sample > bestSample {
+				best, bestSample = pos, sample
+			}
+		}
+		if best < 0 {
+			return 0, false
+		}
+		return f.Creative[best], true
+	}
+
+	var total float64
+	last := -1
+	for pos := range f.Creative {
+		if w := weight(pos); w > 0 {
+			total += w
+			last = pos
+		}
+	}
+	if total <= 0 {
+		return 0, false
+	}
+	at := r.rnd.Float64() * total
+	for pos, id := range f.Creative {
+		if w := weight(pos); w > 0 {
+			at -= w
+			if at < 0 {
+				return id, true
+			}
+		}
+	}
+	return f.Creative[last], true
+}
+
+// weight of the creative at pos, folders built without Weights give all their creatives 1.
+func (f *Folder) weight(pos int) float64 {
+	if pos < len(f.Weights) {
+		return f.Weights[pos]
+	}
+	return 1
+}
+
+func betaSample(rnd *rand.Rand, a, b float64) float64 {
+	x := gammaSample(rnd, a)
+	return x / (x + gammaSample(rnd, b))
+}
+
+// gammaSample is Marsaglia and Tsang's method, valid for shape >= 1 which is all Pick needs.
+func gammaSample(rnd *rand.Rand, shape float64) float64 {
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rnd.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rnd.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package bindings
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRotationWeighted(t *testing.T) {
+	r := &Rotation{rnd: rand.New(rand.NewSource(1))}
+	f := &Folder{ID: 1, Creative: []int{10, 20, 30}, Weights: []float64{3, 1, 0}}
+	picks := map[int]int{}
+	for i := 0; i < 4000; i++ {
+		id, ok := r.Pick(f)
+		if !ok {
+			t.Fatal("no creative picked")
+		}
+		picks[id]++
+	}
+	t.Log("picks", picks)
+	if picks[30] != 0 || picks[10] < 2800 || picks[10] > 3200 {
+		t.Errorf(`picks %v don't follow the weights`, picks)
+	}
+	if _, ok := r.Pick(&Folder{ID: 2}); ok {
+		t.Error("picked from a folder without creatives")
+	}
+}
+
+func TestRotationThompson(t *testing.T) {
+	r := &Rotation{rnd: rand.New(rand.NewSource(1))}
+	f := &Folder{ID: 1, Creative: []int{10, 20}, Rotation: RotateThompson}
+	ctr := map[int]float64{10: 0.02, 20: 0.05}
+	picks := map[int]int{}
+	for i := 0; i < 20000; i++ {
+		id, _ := r.Pick(f)
+		picks[id]++
+		r.Shown(f.ID, id)
+		if r.rnd.Float64() < ctr[id] {
+			r.Succeeded(f.ID, id)
+		}
+	}
+	t.Log("picks", picks)
+	if picks[20] < 3*picks[10] {
+		t.Errorf(`picks %v didn't shift to the better creative`, picks)
+	}
+}
+
+func TestRotationPickFrom(t *testing.T) {
+	eligible := []int{4}
+	rot := &Rotation{rnd: rand.New(rand.NewSource(1))}
+	f := &Folder{ID: 1, Creative: []int{1, 2, 3, 4, 5}}
+	for i := 0; i < 20; i++ {
+		if id, ok := rot.PickFrom(f, eligible); !ok || id != 4 {
+			t.Fatalf(`picked %d, an ineligible creative`, id)
+		}
+	}
+	if _, ok := rot.PickFrom(f, nil); ok {
+		t.Error("picked with nothing eligible")
+	}
+}
+
+func TestRotationConverted(t *testing.T) {
+	r := &Rotation{}
+	r.Converted(&Conversion{SaleID: 1, FolderID: 1, CreativeID: 10})
+	r.Converted(&Conversion{SaleID: 2, ClickID: "abc", FolderID: 1, CreativeID: 10})
+	if fb := r.feedback[rotationKey{1, 10}]; fb == nil || fb.Succeeded != 1 {
+		t.Errorf(`conversions recorded %+v, expected one success`, fb)
+	}
+}
@@ -0,0 +1,169 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+const sqlTaxonomy = `SELECT keyword, COALESCE(vertical_id, 0), COALESCE(interest_id, 0), COALESCE(weight, 1) FROM taxonomy_keywords`
+
+type taxonomyHit struct {
+	VerticalID int
+	InterestID int
+	Weight     float64
+}
+
+// Taxonomy classifies pages into verticals and interests by keyword. The terms of a request are
+// the words (and pairs of words) of its page url's host and path, and its keywords whole and split
+// into words; each term found in the taxonomy scores its weight for its vertical and interest. The
+// top vertical (or interest) is used when it has at least MinScore and Threshold of the total score.
+type Taxonomy struct {
+	Threshold float64
+	MinScore  float64
+
+	keywords map[string][]taxonomyHit
+}
+
+// Classification is a Taxonomy's verdict, ids are 0 when it wasn't confident.
+type Classification struct {
+	VerticalID         int
+	VerticalConfidence float64
+	InterestID         int
+	InterestConfidence float64
+}
+
+func (t *Taxonomy) Unmarshal(depth int, env services.BindingDeps) error {
+	rows, err := env.ConfigDB.Query(sqlTaxonomy)
+	if err != nil {
+		env.Debug.Println("err", err)
+		return err
+	}
+	t.keywords = make(map[string][]taxonomyHit)
+	for rows.Next() {
+		var keyword string
+		var hit taxonomyHit
+		if err := rows.Scan(&keyword, &hit.VerticalID, &hit.InterestID, &hit.Weight); err != nil {
+			env.Debug.Println("err", err)
+			return err
+		}
+		t.Add(keyword, hit.VerticalID, hit.InterestID, hit.Weight)
+	}
+	env.Debug.Printf("LOADED %s %T %d keywords", wide(depth), t, len(t.keywords))
+	return nil
+}
+
+func (t *Taxonomy) Add(keyword string, verticalID, interestID int, weight float64) {
+	if t.keywords == nil {
+		t.keywords = make(map[string][]taxonomyHit)
+	}
+	keyword = strings.Join(words(keyword), " ")
+	t.keywords[keyword] = append(t.keywords[keyword], taxonomyHit{verticalID, interestID, weight})
+}
+
+// words splits text into lowercase words of letters and digits.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// terms are the distinct things of a request looked up in the taxonomy.
+func terms(r *rtb_types.Request) map[string]bool {
+	found := make(map[string]bool)
+	addWords := func(ws []string) {
+		for i, w := range ws {
+			found[w] = true
+			if i > 0 {
+				found[ws[i-1]+" "+w] = true
+			}
+		}
+	}
+	if page := r.Site.Placement; page != "" {
+		if !strings.Contains(page, "://") {
+			page = "http://" + page
+		}
+		if u, err := url.Parse(page); err == nil {
+			host := strings.Split(strings.ToLower(u.Hostname()), ".")
+			if len(host) > 1 {
+				host = host[:len(host)-1]
+			}
+			if len(host) > 0 && host[0] == "www" {
+				host = host[1:]
+			}
+			for _, label := range host {
+				addWords(words(label))
+			}
+			addWords(words(u.Path))
+		}
+	}
+	for _, kw := range r.Site.Keywords {
+		ws := words(kw)
+		found[strings.Join(ws, " ")] = true
+		addWords(ws)
+	}
+	delete(found, "")
+	return found
+}
+
+func (t *Taxonomy) Classify(r *rtb_types.Request) Classification {
+	threshold, minScore := t.Threshold, t.MinScore
+	if threshold == 0 {
+		threshold = 0.6
+	}
+	if minScore == 0 {
+		minScore = 1
+	}
+
+	verticals, interests := map[int]float64{}, map[int]float64{}
+	for term := range terms(r) {
+		for _, hit := range t.keywords[term] {
+			if hit.VerticalID != 0 {
+				verticals[hit.VerticalID] += hit.Weight
+			}
+			if hit.InterestID != 0 {
+				interests[hit.InterestID] += hit.Weight
+			}
+		}
+	}
+
+	var c Classification
+	c.VerticalID, c.VerticalConfidence = pickTop(verticals, threshold, minScore)
+	c.InterestID, c.InterestConfidence = pickTop(interests, threshold, minScore)
+	return c
+}
+
+// pickTop is the highest scoring id with its share of the total, if that's confident enough.
+func pickTop(scores map[int]float64, threshold, minScore float64) (int, float64) {
+	var best int
+	var bestScore, total float64
+	for id, score := range scores {
+		total += score
+		if score > bestScore || (score == bestScore && id < best) {
+			best, bestScore = id, score
+		}
+	}
+	if total <= 0 || bestScore < minScore || bestScore/total < threshold {
+		return 0, 0
+	}
+	return best, bestScore / total
+}
+
+// Fill sets the vertical and interest the request's dimensions are missing, returning whether it
+// set either.
+func (t *Taxonomy) Fill(r *rtb_types.Request, d *rtb_types.Dimensions) bool {
+	if d.VerticalID != 0 && d.InterestID != 0 {
+		return false
+	}
+	c := t.Classify(r)
+	filled := false
+	if d.VerticalID == 0 && c.VerticalID != 0 {
+		d.VerticalID, filled = c.VerticalID, true
+	}
+	if d.InterestID == 0 && c.InterestID != 0 {
+		d.InterestID, filled = c.InterestID, true
+	}
+	return filled
+}
@@ -0,0 +1,43 @@
+package bindings
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"testing"
+)
+
+func TestTaxonomy(t *testing.T) {
+	tx := &Taxonomy{}
+	tx.Add("cars", 1, 10, 1)
+	tx.Add("Used Cars", 1, 11, 2)
+	tx.Add("finance", 2, 0, 1)
+	tx.Add("loan", 2, 0, 1)
+	tx.Add("motors", 1, 0, 1)
+
+	req := func(page string, kws ...string) *rtb_types.Request {
+		r := &rtb_types.Request{}
+		r.Site.Placement = page
+		r.Site.Keywords = kws
+		return r
+	}
+
+	c := tx.Classify(req("http://www.motors.com/used-cars/ford"))
+	if c.VerticalID != 1 || c.VerticalConfidence != 1 {
+		t.Errorf(`expected vertical 1, have %+v`, c)
+	}
+	if c.InterestID != 11 {
+		t.Errorf(`expected "used cars" to outweigh "cars", have %+v`, c)
+	}
+
+	// a term for each vertical is only 0.5 confident
+	if c := tx.Classify(req("http://example.com/cars", "finance")); c.VerticalID != 0 {
+		t.Errorf(`expected no vertical, have %+v`, c)
+	}
+	if c := tx.Classify(req("http://example.com/news")); c.VerticalID != 0 || c.InterestID != 0 {
+		t.Errorf(`expected nothing, have %+v`, c)
+	}
+
+	d := &rtb_types.Dimensions{VerticalID: 5}
+	if !tx.Fill(req("", "cars"), d) || d.VerticalID != 5 || d.InterestID != 10 {
+		t.Errorf(`fill should keep the vertical and add the interest, have %+v`, d)
+	}
+}
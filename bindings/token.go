@@ -0,0 +1,98 @@
+package bindings
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrBadToken is returned for tokens that weren't sealed with the key, or were changed since.
+var ErrBadToken = errors.New("bad token")
+
+// ErrNoKey is returned by a Sealer without a Key, whose tokens anyone could forge.
+var ErrNoKey = errors.New("no sealing key")
+
+// Sealer is B64's successor for tokens that leave us: AES-GCM keyed by a hash of Key, so they're
+// authenticated as well as encrypted, and url safe base64.
+type Sealer struct {
+	Key []byte
+}
+
+func (s *Sealer) aead() (cipher.AEAD, error) {
+	if len(s.Key) == 0 {
+		return nil, ErrNoKey
+	}
+	key := sha256.Sum256(s.Key)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal json encodes v and encrypts it.
+func (s *Sealer) Seal(v interface{}) (string, error) {
+	pt, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, pt, nil)), nil
+}
+
+// Open decrypts a token from Seal into v.
+func (s *Sealer) Open(token string, v interface{}) error {
+	ct, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ErrBadToken
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+	if len(ct) < aead.NonceSize() {
+		return ErrBadToken
+	}
+	pt, err := aead.Open(nil, ct[:aead.NonceSize()], ct[aead.NonceSize():], nil)
+	if err != nil {
+		return ErrBadToken
+	}
+	return json.Unmarshal(pt, v)
+}
+
+// ClickToken is sealed into the rurl of a bid so the click can be tracked and redirected. It
+// carries the macro values known at bid time, the click id is made when the click arrives. ID
+// is random per token, so repeat clicks on one token can be told apart from new ones.
+type ClickToken struct {
+	ID         string  `json:"i,omitempty"`
+	SaleID     int     `json:"s"`
+	SSPID      int     `json:"p"`
+	FolderID   int     `json:"f"`
+	CreativeID int     `json:"c"`
+	Keyword    string  `json:"k,omitempty"`
+	Country    string  `json:"co,omitempty"`
+	Placement  string  `json:"pl,omitempty"`
+	Price      float64 `json:"pr,omitempty"`
+	Expires    int64   `json:"e"`
+}
+
+func (t *ClickToken) Expired(now time.Time) bool {
+	return now.Unix() > t.Expires
+}
+
+// Macros are the token's macro values for the click.
+func (t *ClickToken) Macros(clickID string) *MacroValues {
+	return &MacroValues{ClickID: clickID, SSPID: t.SSPID, FolderID: t.FolderID, CreativeID: t.CreativeID, Keyword: t.Keyword, Country: t.Country, Placement: t.Placement, Price: t.Price}
+}
@@ -0,0 +1,48 @@
+package bindings
+
+import (
+	"fmt"
+	"github.com/clixxa/dsp/services"
+	"strings"
+)
+
+// ConfigErrors collects problems with individual config rows. The rows are disabled rather than
+// failing the whole load, so these are reported for someone to fix.
+type ConfigErrors []error
+
+func (c ConfigErrors) Error() string {
+	str := make([]string, len(c))
+	for i, e := range c {
+		str[i] = e.Error()
+	}
+	return fmt.Sprintf(`%d config problems: %s`, len(c), strings.Join(str, "; "))
+}
+
+// Validate returns the problems found while loading the folders as services.ErrParsing's inside
+// ConfigErrors, or nil if there were none.
+func (f *Folders) Validate() error {
+	var errs ConfigErrors
+	for _, fo := range *f {
+		for _, p := range fo.Problems {
+			errs = append(errs, services.ErrParsing{What: fmt.Sprintf(`folder %d`, fo.ID), UnderlyingErr: p})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Validate returns the problems found while loading the creatives, like Folders.Validate.
+func (c *Creatives) Validate() error {
+	var errs ConfigErrors
+	for _, cr := range *c {
+		for _, p := range cr.Problems {
+			errs = append(errs, services.ErrParsing{What: fmt.Sprintf(`creative %d`, cr.ID), UnderlyingErr: p})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/clixxa/dsp/pricing"
+	"math"
+	"os"
+)
+
+// ctr_eval reports the log loss of an online model on a held-out log. The model comes from a
+// checkpoint, or is trained from a log first. Logs are json lines of pricing.Example.
+func main() {
+	checkpoint := flag.String("checkpoint", "", "model checkpoint to evaluate")
+	train := flag.String("train", "", "log to train on first")
+	test := flag.String("test", "", "held-out log to evaluate on")
+	bits := flag.Uint("bits", 20, "feature hash bits, when training from scratch")
+	save := flag.Bool("save", false, "write the trained model back to -checkpoint")
+	flag.Parse()
+
+	if *test == "" {
+		fmt.Println("need a -test log")
+		os.Exit(2)
+	}
+
+	m := &pricing.Online{Bits: *bits, Path: *checkpoint}
+	if *checkpoint != "" {
+		if err := m.Load(); err != nil {
+			fmt.Println("loading checkpoint:", err)
+			os.Exit(1)
+		}
+	}
+	if *train != "" {
+		examples, err := readLog(*train)
+		if err != nil {
+			fmt.Println("reading training log:", err)
+			os.Exit(1)
+		}
+		for i := range examples {
+			m.Update(pricing.Features(&examples[i].Context, m.Bits), examples[i].Converted)
+		}
+		fmt.Printf("trained on %d examples\n", len(examples))
+		if *save && *checkpoint != "" {
+			if err := m.Save(); err != nil {
+				fmt.Println("saving checkpoint:", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	examples, err := readLog(*test)
+	if err != nil {
+		fmt.Println("reading test log:", err)
+		os.Exit(1)
+	}
+	positives := 0
+	for _, e := range examples {
+		if e.Converted {
+			positives++
+		}
+	}
+	fmt.Printf("examples %d, positives %d\n", len(examples), positives)
+	fmt.Printf("log loss %f\n", m.LogLoss(examples))
+	if positives > 0 && positives < len(examples) {
+		p := float64(positives) / float64(len(examples))
+		fmt.Printf("log loss predicting the average %f\n", -(p*math.Log(p) + (1-p)*math.Log(1-p)))
+	}
+}
+
+func readLog(path string) ([]pricing.Example, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var examples []pricing.Example
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var e pricing.Example
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		examples = append(examples, e)
+	}
+	return examples, sc.Err()
+}
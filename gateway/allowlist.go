@@ -0,0 +1,50 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/services"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Allowlist only lets an ssp's own servers through to Next, answering anyone else with a 403.
+// Bids name their ssp in the path; win notices may name it with the ssp query parameter, and
+// without it are accepted from any ssp's servers. TrustedProxies is how many proxies in front of
+// us append to X-Forwarded-For, see ClientIP.
+type Allowlist struct {
+	Next           http.Handler
+	SSPs           *SSPs
+	Counters       *services.Counters
+	TrustedProxies int
+}
+
+func (a *Allowlist) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ssp := SSPID(r)
+	if !a.SSPs.FromServer(ssp, ClientIP(r, a.TrustedProxies)) {
+		a.Counters.Inc(ssp, "ip_rejected")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	a.Next.ServeHTTP(w, r)
+}
+
+// ClientIP is the address of the caller. Behind trustedProxies proxies it's the X-Forwarded-For
+// address the outermost of them appended, counting from the right, as anything further left was
+// written by the caller. Without enough addresses in the header there's no trustworthy address.
+func ClientIP(r *http.Request, trustedProxies int) string {
+	if trustedProxies > 0 {
+		var hops []string
+		for _, h := range r.Header["X-Forwarded-For"] {
+			hops = append(hops, strings.Split(h, ",")...)
+		}
+		if len(hops) < trustedProxies {
+			return ""
+		}
+		return strings.TrimSpace(hops[len(hops)-trustedProxies])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
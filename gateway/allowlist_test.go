@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/7", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Add("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	r.Header.Add("X-Forwarded-For", "3.3.3.3")
+	for proxies, want := range map[int]string{0: "10.0.0.1", 1: "3.3.3.3", 2: "2.2.2.2", 3: "1.1.1.1", 4: ""} {
+		if got := ClientIP(r, proxies); got != want {
+			t.Errorf(`behind %d proxies got %q want %q`, proxies, got, want)
+		}
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	ssps := &SSPs{servers: map[int]*CIDRSet{7: {}}, all: &CIDRSet{}}
+	ssps.servers[7].Add("5.5.5.0/24")
+	ssps.servers[7].Compact()
+	ssps.all.Add("5.5.5.0/24")
+	ssps.all.Compact()
+	a := &Allowlist{Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), SSPs: ssps, TrustedProxies: 1}
+
+	code := func(path, forwarded string) int {
+		r := httptest.NewRequest("POST", path, nil)
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, tc := range []struct {
+		path, forwarded string
+		code            int
+	}{
+		{"/7", "5.5.5.5", http.StatusOK},
+		{"/7", "9.9.9.9", http.StatusForbidden},
+		// the caller wrote the left entry, our proxy the right one
+		{"/7", "5.5.5.5, 9.9.9.9", http.StatusForbidden},
+		{"/7", "", http.StatusForbidden},
+		{"/win", "5.5.5.5", http.StatusOK},
+		{"/win", "9.9.9.9", http.StatusForbidden},
+		// ssp 8 has no servers listed
+		{"/8", "5.5.5.5", http.StatusForbidden},
+	} {
+		if got := code(tc.path, tc.forwarded); got != tc.code {
+			t.Errorf(`%s from %q: got %d want %d`, tc.path, tc.forwarded, got, tc.code)
+		}
+	}
+
+	ssps.AllowUnlisted = true
+	if got := code("/8", "9.9.9.9"); got != http.StatusOK {
+		t.Errorf(`unlisted ssp with AllowUnlisted got %d`, got)
+	}
+	empty := &Allowlist{Next: a.Next, SSPs: &SSPs{}}
+	w := httptest.NewRecorder()
+	empty.ServeHTTP(w, httptest.NewRequest("GET", "/win", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf(`no ssp servers configured got %d`, w.Code)
+	}
+}
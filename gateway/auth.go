@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/clixxa/dsp/services"
+	"gopkg.in/redis.v5"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Auth checks bid and win calls come from the ssp they claim, using its AuthKey either as a bearer
+// token (Authorization: Bearer <key>) or to sign the call: X-Timestamp is the unix time and
+// X-Signature the hex HMAC-SHA256 of the timestamp, a newline and the body. Signed calls must be
+// within Window of now, and each signature is only accepted once. SSPs without an AuthKey aren't
+// checked; a win without an ssp must carry credentials of some ssp, if any have keys.
+// Missing or malformed credentials get a 401, wrong, stale or replayed ones a 403.
+type Auth struct {
+	Next     http.Handler
+	SSPs     *SSPs
+	KVS      *redis.Client
+	Window   time.Duration
+	Counters *services.Counters
+
+	lock     sync.Mutex
+	seen     map[string]bool
+	lastSeen map[string]bool
+	rotated  time.Time
+}
+
+func (a *Auth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ssp := SSPID(r)
+	var keys []string
+	if ssp != 0 {
+		if u := a.SSPs.ByID(ssp); u != nil && u.AuthKey != "" {
+			keys = append(keys, u.AuthKey)
+		}
+	} else {
+		keys = a.SSPs.AuthKeys()
+	}
+	if len(keys) == 0 {
+		a.Next.ServeHTTP(w, r)
+		return
+	}
+
+	status := http.StatusUnauthorized
+	if bearer := r.Header.Get("Authorization"); bearer != "" {
+		if strings.HasPrefix(bearer, "Bearer ") {
+			status = a.bearer(keys, strings.TrimPrefix(bearer, "Bearer "))
+		}
+	} else if sig := r.Header.Get("X-Signature"); sig != "" {
+		b := bytes.NewBuffer(nil)
+		if _, err := b.ReadFrom(r.Body); err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(b.Bytes()))
+		status = a.signature(keys, r.Header.Get("X-Timestamp"), sig, b.Bytes())
+	}
+
+	if status != http.StatusOK {
+		a.Counters.Inc(ssp, "auth_"+strconv.Itoa(status))
+		w.WriteHeader(status)
+		return
+	}
+	a.Next.ServeHTTP(w, r)
+}
+
+func (a *Auth) bearer(keys []string, token string) int {
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			return http.StatusOK
+		}
+	}
+	return http.StatusForbidden
+}
+
+func (a *Auth) signature(keys []string, timestamp, sig string, body []byte) int {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return http.StatusUnauthorized
+	}
+	given, err := hex.DecodeString(sig)
+	if err != nil {
+		return http.StatusUnauthorized
+	}
+	window := a.Window
+	if window == 0 {
+		window = 5 * time.Minute
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > window || age < -window {
+		return http.StatusForbidden
+	}
+
+	for _, key := range keys {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(timestamp + "\n"))
+		mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), given) {
+			if !a.firstUse(hex.EncodeToString(given), 2*window) {
+				return http.StatusForbidden
+			}
+			return http.StatusOK
+		}
+	}
+	return http.StatusForbidden
+}
+
+// firstUse records a signature (the decoded mac, so re-casing the hex doesn't make it new),
+// returning false if it was already seen within ttl. Without redis it's remembered in two
+// generations that rotate every ttl, so each is kept between one and two ttls.
+func (a *Auth) firstUse(mac string, ttl time.Duration) bool {
+	if a.KVS != nil {
+		if ok, err := a.KVS.SetNX("auth:"+mac, "1", ttl).Result(); err == nil {
+			return ok
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if now := time.Now(); a.seen == nil || now.Sub(a.rotated) > ttl {
+		a.lastSeen = a.seen
+		if now.Sub(a.rotated) > 2*ttl {
+			a.lastSeen = nil
+		}
+		a.seen, a.rotated = make(map[string]bool), now
+	}
+	if a.seen[mac] || a.lastSeen[mac] {
+		return false
+	}
+	a.seen[mac] = true
+	return true
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/services"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuth(t *testing.T) {
+	ssps := &SSPs{users: bindings.Users{{ID: 3, AuthKey: "secret"}, {ID: 4}}}
+	a := &Auth{Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), SSPs: ssps, Counters: &services.Counters{}}
+
+	sign := func(key string, ts int64, body string) (string, string) {
+		mac := hmac.New(sha256.New, []byte(key))
+		stamp := strconv.FormatInt(ts, 10)
+		mac.Write([]byte(stamp + "\n" + body))
+		return stamp, hex.EncodeToString(mac.Sum(nil))
+	}
+	now := time.Now().Unix()
+	stamp, sig := sign("secret", now, "{}")
+	oldStamp, oldSig := sign("secret", now-3600, "{}")
+
+	cases := []struct {
+		path, bearer, stamp, sig string
+		want                     int
+	}{
+		{"/4", "", "", "", 200},
+		{"/3", "", "", "", 401},
+		{"/3", "Bearer secret", "", "", 200},
+		{"/3", "Bearer wrong", "", "", 403},
+		{"/3", "Basic c2VjcmV0", "", "", 401},
+		{"/3", "", stamp, sig, 200},
+		{"/3", "", stamp, sig, 403},
+		{"/3", "", stamp, strings.ToUpper(sig), 403},
+		{"/3", "", oldStamp, oldSig, 403},
+		{"/3", "", stamp, "zz", 401},
+		{"/win", "Bearer secret", "", "", 200},
+		{"/win", "", "", "", 401},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("POST", c.path, bytes.NewBufferString("{}"))
+		if c.bearer != "" {
+			r.Header.Set("Authorization", c.bearer)
+		}
+		if c.sig != "" {
+			r.Header.Set("X-Timestamp", c.stamp)
+			r.Header.Set("X-Signature", c.sig)
+		}
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf(`case %d (%s): got %d want %d`, i, c.path, w.Code, c.want)
+		}
+	}
+	t.Log(a.Counters)
+}
+
+func TestAuthSeenExpires(t *testing.T) {
+	a := &Auth{}
+	if !a.firstUse("a", time.Hour) || a.firstUse("a", time.Hour) {
+		t.Fatal("a mac should only be accepted once")
+	}
+	a.rotated = a.rotated.Add(-90 * time.Minute)
+	if a.firstUse("a", time.Hour) {
+		t.Error("forgot a mac after one rotation")
+	}
+	a.rotated = a.rotated.Add(-3 * time.Hour)
+	if !a.firstUse("a", time.Hour) {
+		t.Error("remembered a mac for more than two ttls")
+	}
+}
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"os"
+	"sync"
+)
+
+const NoBidBrandSafety = "brand_safety"
+
+// BrandSafety turns away requests for pages on the global blocklist, the blocklist table of the
+// config db plus the file at Path (TBLOCKLIST), both reread each cycle. Per folder lists are
+// checked by bindings.Folder.Targets.
+type BrandSafety struct {
+	BindingDeps services.BindingDeps
+	Path        string
+
+	lock sync.RWMutex
+	list *bindings.Blocklist
+}
+
+func (b *BrandSafety) Cycle(quit func(error) bool) {
+	if b.Path == "" {
+		b.Path = os.Getenv("TBLOCKLIST")
+	}
+
+	// a list that didn't load completely would let through pages the last one blocked, so keep
+	// the last one until this one loads
+	list := &bindings.Blocklist{}
+	if b.BindingDeps.ConfigDB != nil {
+		if err := list.Unmarshal(0, b.BindingDeps); err != nil {
+			quit(services.ErrDatabaseMissing{Name: "blocklist", UnderlyingErr: err})
+			return
+		}
+	}
+	if b.Path != "" {
+		if err := list.AddFile(b.Path); err != nil {
+			quit(services.ErrDatabaseMissing{Name: "blocklist " + b.Path, UnderlyingErr: err})
+			return
+		}
+	}
+	for _, p := range list.Problems {
+		if quit(services.ErrParsing{What: "blocklist", UnderlyingErr: p}) {
+			return
+		}
+	}
+
+	b.lock.Lock()
+	b.list = list
+	b.lock.Unlock()
+}
+
+func (b *BrandSafety) Check(ssp int, r *rtb_types.Request) string {
+	b.lock.RLock()
+	list := b.list
+	b.lock.RUnlock()
+	if list.Blocks(r.Site.Placement) {
+		return NoBidBrandSafety
+	}
+	return ""
+}
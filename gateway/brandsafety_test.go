@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBrandSafety(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brandsafety")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blocklist")
+	if err := ioutil.WriteFile(path, []byte("bad.com # whole domain\nnews.com/politics\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	quits := 0
+	quit := func(err error) bool {
+		quits++
+		return false
+	}
+	b := &BrandSafety{Path: path}
+	b.Cycle(quit)
+
+	check := func(page string) string {
+		r := &rtb_types.Request{}
+		r.Site.Placement = page
+		return b.Check(1, r)
+	}
+	if check("http://www.bad.com/x") != NoBidBrandSafety || check("http://news.com/politics/a") != NoBidBrandSafety {
+		t.Error("blocklisted pages got through")
+	}
+	if check("http://news.com/sport") != "" || check("") != "" {
+		t.Error("blocked a page that isn't listed")
+	}
+
+	// a file that's gone keeps the last list, and is reported
+	os.Remove(path)
+	b.Cycle(quit)
+	if quits != 1 || check("http://bad.com/") != NoBidBrandSafety {
+		t.Errorf(`lost the blocklist after a failed reload, %d errors reported`, quits)
+	}
+}
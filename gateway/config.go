@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"fmt"
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Config holds the folders, creatives and pseudonyms bids are finished with, and the keyword
+// taxonomy that fills in the vertical and interest of requests that don't name them, reloaded from
+// the config db each cycle. A cycle that fails to load any of them keeps the last ones. Threshold
+// (TCONTEXTTHRESHOLD) is the taxonomy's confidence threshold, in (0, 1].
+type Config struct {
+	BindingDeps services.BindingDeps
+	Threshold   float64
+
+	lock      sync.RWMutex
+	folders   bindings.Folders
+	creatives bindings.Creatives
+	names     *bindings.Pseudonyms
+	taxonomy  *bindings.Taxonomy
+}
+
+func (c *Config) Cycle(quit func(error) bool) {
+	if c.BindingDeps.ConfigDB == nil {
+		return
+	}
+	folders := bindings.Folders{}
+	if err := folders.Unmarshal(0, c.BindingDeps); err != nil {
+		quit(services.ErrDatabaseMissing{Name: "folders", UnderlyingErr: err})
+		return
+	}
+	creatives := bindings.Creatives{}
+	if err := creatives.Unmarshal(0, c.BindingDeps); err != nil {
+		quit(services.ErrDatabaseMissing{Name: "creatives", UnderlyingErr: err})
+		return
+	}
+	names := &bindings.Pseudonyms{}
+	if err := names.Unmarshal(0, c.BindingDeps); err != nil {
+		quit(services.ErrDatabaseMissing{Name: "pseudonyms", UnderlyingErr: err})
+		return
+	}
+
+	c.lock.Lock()
+	c.folders, c.creatives, c.names = folders, creatives, names
+	c.lock.Unlock()
+
+	threshold, err := c.threshold()
+	if quit(services.ErrParsing{What: "TCONTEXTTHRESHOLD", UnderlyingErr: err}) {
+		return
+	}
+	taxonomy := &bindings.Taxonomy{Threshold: threshold}
+	if err := taxonomy.Unmarshal(0, c.BindingDeps); err != nil {
+		quit(services.ErrDatabaseMissing{Name: "taxonomy", UnderlyingErr: err})
+		return
+	}
+	c.lock.Lock()
+	c.taxonomy = taxonomy
+	c.lock.Unlock()
+}
+
+// threshold is the taxonomy's threshold, 0 (its default) when neither Threshold nor
+// TCONTEXTTHRESHOLD is set.
+func (c *Config) threshold() (float64, error) {
+	threshold := c.Threshold
+	if env := os.Getenv("TCONTEXTTHRESHOLD"); threshold == 0 && env != "" {
+		var err error
+		if threshold, err = strconv.ParseFloat(env, 64); err != nil {
+			return 0, err
+		}
+		if threshold == 0 {
+			return 0, fmt.Errorf(`threshold %q isn't in (0, 1]`, env)
+		}
+	}
+	if threshold < 0 || threshold > 1 || math.IsNaN(threshold) {
+		return 0, fmt.Errorf(`threshold %f isn't in (0, 1]`, threshold)
+	}
+	return threshold, nil
+}
+
+// Loaded returns the current config, nil until the first cycle loads it.
+func (c *Config) Loaded() (bindings.Folders, bindings.Creatives, *bindings.Pseudonyms) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.folders, c.creatives, c.names
+}
+
+// Dimensions resolves the names in a request to the ids folders target, classifying the page for
+// a vertical and interest the request doesn't name.
+func (c *Config) Dimensions(r *rtb_types.Request) *rtb_types.Dimensions {
+	d := &rtb_types.Dimensions{}
+	c.lock.RLock()
+	names, taxonomy := c.names, c.taxonomy
+	c.lock.RUnlock()
+	if names == nil {
+		return d
+	}
+	d.VerticalID = lookup(names.Verticals, r.Site.Vertical)
+	if d.BrandID = lookup(names.Brands, r.Site.Brand); d.BrandID == 0 {
+		d.BrandID = lookup(names.BrandSlugs, r.Site.Brand)
+	}
+	if d.SubNetworkID = lookup(names.Subnetworks, r.Site.SubNetwork); d.SubNetworkID == 0 {
+		d.SubNetworkID = lookup(names.SubnetworkLabels, r.Site.SubNetwork)
+	}
+	if d.NetworkID = lookup(names.Networks, r.Site.Network); d.NetworkID == 0 {
+		d.NetworkID = names.SubnetworkToNetwork[d.SubNetworkID]
+	}
+	if d.NetworkTypeID = lookup(names.NetworkTypes, r.Site.NetworkType); d.NetworkTypeID == 0 {
+		d.NetworkTypeID = names.NetworkToNetworkType[d.NetworkID]
+	}
+	d.DeviceTypeID = lookup(names.DeviceTypes, r.Device.DeviceType)
+	d.OSID = lookup(names.OSes, r.Device.OS)
+	d.BrowserID = lookup(names.Browsers, r.Device.Browser)
+	d.CountryID = lookup(names.Countries, r.Device.Geo.Country)
+	d.GenderID = lookup(names.Genders, r.User.Gender)
+	d.InterestID = lookup(names.Interests, r.User.Interest)
+	d.AngleID = lookup(names.Angles, r.Site.Angle)
+	// subchannel labels are only unique within their channel, the network
+	if r.Site.Subchannel != "" {
+		d.SubchannelID = names.SubchannelID(d.NetworkID, r.Site.Subchannel)
+	}
+	if taxonomy != nil {
+		taxonomy.Fill(r, d)
+	}
+	return d
+}
+
+// lookup finds a name as sent, or lowercased, in a Pseudonyms map.
+func lookup(ids map[string]int, name string) int {
+	if name == "" {
+		return 0
+	}
+	if id, ok := ids[name]; ok {
+		return id
+	}
+	return ids[strings.ToLower(name)]
+}
@@ -0,0 +1,139 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/clixxa/dsp/services"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Deadline makes sure we answer before the ssp's auction closes. The time allowed is the request's
+// tmax (milliseconds, in the openrtb body or the url method's query), else the ssp's TMax, else
+// DefaultTMax; with none of them the request has no deadline. Next runs with a context that ends
+// Margin before the deadline, and if it hasn't answered by then the ssp gets a 204 and whatever
+// Next writes later is dropped. The request isn't over until Next returns, so it stays in flight
+// (see Throttle) while Next winds down.
+type Deadline struct {
+	Next        http.Handler
+	SSPs        *SSPs
+	DefaultTMax time.Duration
+	Margin      time.Duration
+	Counters    *services.Counters
+}
+
+func (d *Deadline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ssp := SSPID(r)
+
+	tmax := d.DefaultTMax
+	if u := d.SSPs.ByID(ssp); u != nil && u.TMax > 0 {
+		tmax = time.Duration(u.TMax) * time.Millisecond
+	}
+	if ms, err := strconv.Atoi(r.URL.Query().Get("tmax")); err == nil && ms > 0 {
+		tmax = time.Duration(ms) * time.Millisecond
+	}
+	if r.Method == "POST" {
+		b := bytes.NewBuffer(nil)
+		if _, err := b.ReadFrom(r.Body); err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(b.Bytes()))
+		var peek struct {
+			TMax int `json:"tmax"`
+		}
+		if json.Unmarshal(b.Bytes(), &peek) == nil && peek.TMax > 0 {
+			tmax = time.Duration(peek.TMax) * time.Millisecond
+		}
+	}
+	if tmax <= 0 {
+		d.Next.ServeHTTP(w, r)
+		return
+	}
+
+	margin := d.Margin
+	if margin == 0 {
+		margin = 10 * time.Millisecond
+	}
+	ctx, cancel := context.WithDeadline(r.Context(), start.Add(tmax-margin))
+	defer cancel()
+
+	buf := &bufferedResponse{header: make(http.Header)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Next.ServeHTTP(buf, r.WithContext(ctx))
+	}()
+
+	select {
+	case <-done:
+		buf.flushTo(w)
+	case <-ctx.Done():
+		buf.abandon()
+		d.Counters.Inc(ssp, "deadline")
+		w.Header().Set("X-Nobid-Reason", "deadline")
+		w.WriteHeader(http.StatusNoContent)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		<-done
+	}
+}
+
+// bufferedResponse holds a response until we know it's in time to send.
+type bufferedResponse struct {
+	lock      sync.Mutex
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	abandoned bool
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.status == 0 {
+		b.status = 200
+	}
+	if b.abandoned {
+		return len(p), nil
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) abandon() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.abandoned = true
+}
+
+func (b *bufferedResponse) flushTo(w http.ResponseWriter) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.status == 0 {
+		b.status = 200
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	counters := &services.Counters{}
+	d := &Deadline{SSPs: &SSPs{}, Margin: time.Millisecond, Counters: counters}
+	d.Next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") != "" {
+			<-r.Context().Done()
+			time.Sleep(5 * time.Millisecond)
+		}
+		w.Write([]byte("bid"))
+	})
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(httptest.NewRequest("GET", "/1?tmax=50", nil)); w.Code != http.StatusOK || w.Body.String() != "bid" {
+		t.Errorf(`a quick bid got %d %q`, w.Code, w.Body.String())
+	}
+	start := time.Now()
+	w := serve(httptest.NewRequest("POST", "/1?slow=1", strings.NewReader(`{"tmax":20}`)))
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 || w.Header().Get("X-Nobid-Reason") != "deadline" {
+		t.Errorf(`a late bid got %d %q`, w.Code, w.Body.String())
+	}
+	if took := time.Since(start); took > 50*time.Millisecond {
+		t.Errorf(`answered after %s, the tmax was 20ms`, took)
+	}
+	if counters.Get(1, "deadline") != 1 {
+		t.Error("the missed deadline wasn't counted")
+	}
+
+	// without any tmax there's no deadline
+	if w := serve(httptest.NewRequest("POST", "/1", strings.NewReader(`{}`))); w.Code != http.StatusOK {
+		t.Errorf(`got %d without a tmax`, w.Code)
+	}
+}
+
+func TestDeadlineInFlight(t *testing.T) {
+	gaveUp, release := make(chan struct{}), make(chan struct{})
+	d := &Deadline{SSPs: &SSPs{}, Margin: time.Millisecond, Counters: &services.Counters{}}
+	d.Next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(gaveUp)
+		<-release
+	})
+	th := &Throttle{Next: d, SSPs: &SSPs{}, Counters: &services.Counters{}}
+
+	served := make(chan struct{})
+	w := httptest.NewRecorder()
+	go func() {
+		defer close(served)
+		th.ServeHTTP(w, httptest.NewRequest("GET", "/1?tmax=10", nil))
+	}()
+	<-gaveUp
+	time.Sleep(5 * time.Millisecond)
+	if th.InFlight() != 1 {
+		t.Error("the request left flight before its bidder did")
+	}
+	close(release)
+	<-served
+	if th.InFlight() != 0 || w.Code != http.StatusNoContent {
+		t.Errorf(`got %d, %d in flight`, w.Code, th.InFlight())
+	}
+}
@@ -0,0 +1,293 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/pricing"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Finisher sits between the gate and the bidder and finishes the bids it makes. The bidder names
+// each bid's folder and creative in its ext (see rtb_types.BidExt), which is removed, and bids on
+// folders that aren't live, don't hold the creative or don't target the request are dropped.
+// Rotation, when there is one, then picks which of the folder's creatives the bid shows, and bids
+// left with no creative the request doesn't block (see Creatives.Eligible) are dropped. The
+// folder's price (Folder.Price) is turned into a cpm by Pricer, bids under the floor (the matched
+// deal's, see User.MatchDeal) are dropped, and the rest are shaded (when there's a Shader), marked
+// with the deal, converted from the home currency to the response's and written to the bid log
+// (Messages). The rurl becomes a click url from Clicks, or the destination with its macros expanded
+// when there's none, and the bid is held by Wins for its win notice, unless the deadline (see
+// Deadline) passed meanwhile. Bids that can't be finished are dropped, counted as finish_ plus the
+// reason, and a response left without bids is a 204, as is a private auction we have no deal for.
+// Url method requests, and responses it can't decode, are passed on untouched.
+type Finisher struct {
+	Next     http.Handler
+	Config   *Config
+	SSPs     *SSPs
+	FX       *bindings.FXRates
+	Pricer   *pricing.CPMPricer
+	Shader   *pricing.Shader
+	Clicks   ClickURLs
+	Wins     *Wins
+	Rotation *bindings.Rotation
+	Counters *services.Counters
+	Messages chan string
+}
+
+// ClickURLs seals a click into the url a bid's rurl points at, tracking.Redirects in production.
+type ClickURLs interface {
+	URL(t *bindings.ClickToken) (string, error)
+}
+
+// finishing is what's known about a request while its bids are finished.
+type finishing struct {
+	ssp       int
+	user      *bindings.User
+	request   *rtb_types.Request
+	imp       *rtb_types.Impression
+	deal      *bindings.DealMatch
+	floor     float64
+	dims      *rtb_types.Dimensions
+	page      *bindings.Page
+	folders   bindings.Folders
+	creatives bindings.Creatives
+	currency  string
+	at        time.Time
+	ctx       context.Context
+}
+
+func (f *Finisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		f.Next.ServeHTTP(w, r)
+		return
+	}
+	b := bytes.NewBuffer(nil)
+	if _, err := b.ReadFrom(r.Body); err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(b.Bytes()))
+
+	buf := &bufferedResponse{header: make(http.Header)}
+	f.Next.ServeHTTP(buf, r)
+	if r.Context().Err() != nil {
+		// the deadline has passed and the ssp has its 204, these bids are never sent
+		f.NoBid(w, SSPID(r), "deadline")
+		return
+	}
+
+	req := &rtb_types.Request{}
+	resp := &rtb_types.Response{}
+	folders, creatives, _ := f.Config.Loaded()
+	if buf.status != 0 && buf.status != http.StatusOK || folders == nil || json.Unmarshal(b.Bytes(), req) != nil || json.Unmarshal(buf.body.Bytes(), resp) != nil {
+		buf.flushTo(w)
+		return
+	}
+
+	ssp := SSPID(r)
+	fin := &finishing{
+		ssp:       ssp,
+		user:      f.SSPs.ByID(ssp),
+		request:   req,
+		imp:       req.FirstImpression(),
+		dims:      f.Config.Dimensions(req),
+		page:      bindings.ParsePage(req.Site.Placement),
+		folders:   folders,
+		creatives: creatives,
+		currency:  resp.Currency,
+		at:        time.Now(),
+		ctx:       r.Context(),
+	}
+	if fin.currency == "" {
+		fin.currency = fin.imp.BidFloorCur
+	}
+	if fin.currency == "" && fin.user != nil {
+		fin.currency = fin.user.Currency
+	}
+
+	user := fin.user
+	if user == nil {
+		user = &bindings.User{}
+	}
+	var ok bool
+	if fin.deal, ok = user.MatchDeal(fin.imp); !ok {
+		f.NoBid(w, ssp, "private_auction")
+		return
+	}
+	floor, err := f.FX.FloorHome(fin.imp, fin.deal)
+	if err != nil {
+		f.NoBid(w, ssp, "currency")
+		return
+	}
+	fin.floor = floor
+
+	finished := 0
+	for i := range resp.SeatBids {
+		var kept []rtb_types.Bid
+		for _, bid := range resp.SeatBids[i].Bids {
+			if reason := f.finish(fin, &bid); reason != "" {
+				f.Counters.Inc(ssp, "finish_"+reason)
+				continue
+			}
+			kept = append(kept, bid)
+		}
+		resp.SeatBids[i].Bids = kept
+		finished += len(kept)
+	}
+	if finished == 0 {
+		f.NoBid(w, ssp, "finish")
+		return
+	}
+	resp.Currency = fin.currency
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		buf.flushTo(w)
+		return
+	}
+	for k, v := range buf.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(js)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(js)
+}
+
+// finish prices a bid, returning why it can't be made, or "" when it can.
+func (f *Finisher) finish(fin *finishing, bid *rtb_types.Bid) string {
+	ext := bid.Ext
+	bid.Ext = nil
+	if ext == nil {
+		return "no_ext"
+	}
+	folder := fin.folders.ByID(ext.FolderID)
+	if folder == nil || !folder.Active || !holds(folder.Creative, ext.CreativeID) {
+		return "no_folder"
+	}
+	if !folder.Targets(fin.request, fin.dims, fin.page) {
+		return "no_target"
+	}
+	creative := fin.creatives.ByID(ext.CreativeID)
+	if creative == nil || !creative.Active {
+		return "no_creative"
+	}
+	eligible := fin.creatives.Eligible(folder.Creative, fin.request, fin.imp, f.Counters, fin.ssp)
+	if f.Rotation != nil {
+		picked, ok := f.Rotation.PickFrom(folder, eligible)
+		if !ok {
+			return "blocked"
+		}
+		creative = fin.creatives.ByID(picked)
+	} else if !holds(eligible, creative.ID) {
+		return "blocked"
+	}
+	breakdown := folder.Price(fin.request, fin.dims, fin.at)
+	if breakdown == nil {
+		return "no_range"
+	}
+	c := &pricing.Context{
+		SSPID:      fin.ssp,
+		FolderID:   folder.ID,
+		CreativeID: creative.ID,
+		Placement:  fin.request.Site.Placement,
+		Request:    fin.request,
+		Dimensions: fin.dims,
+	}
+	cpm, ctr := f.Pricer.CPM(breakdown.Final, c)
+	if cpm < fin.floor {
+		return "below_floor"
+	}
+	fin.deal.Apply(bid)
+	creative.Describe(bid)
+	shaded := cpm
+	if f.Shader != nil {
+		shaded = f.Shader.Shade(folder, c, cpm, fin.floor)
+	}
+	price, err := f.FX.FromHome(shaded, fin.currency)
+	if err != nil {
+		return "currency"
+	}
+	if price <= 0 {
+		return "no_price"
+	}
+	bid.Price = price
+	if bid.URL, err = f.clickURL(fin, folder, creative, bid, shaded); err != nil {
+		return "click_url"
+	}
+
+	// the bid is made, so it's held for its win notice, unless it's too late to be sent
+	if bid.ID != 0 && fin.ctx.Err() == nil {
+		if f.Shader != nil {
+			f.Shader.Bid(int(bid.ID), c, shaded)
+		}
+		if f.Wins != nil {
+			f.Wins.Hold(fin.ctx, int(bid.ID), &HeldBid{
+				SSPID:      fin.ssp,
+				FolderID:   folder.ID,
+				CreativeID: creative.ID,
+				DealID:     bid.DealID,
+				Placement:  fin.request.Site.Placement,
+				Test:       fin.request.Test,
+				Dimensions: *fin.dims,
+				Features:   pricing.Features(c, 32),
+			})
+		}
+	}
+
+	f.log(fmt.Sprintf(`bid ssp %d folder %d creative %d: %s, ctr %.5f, value %.3f, shaded %.3f, cpm %.3f %s, floor %.3f, deal %q`, fin.ssp, folder.ID, creative.ID, breakdown, ctr, cpm, shaded, price, fin.currency, fin.floor, bid.DealID))
+	return ""
+}
+
+// clickURL is where the bid sends the user, through Clicks when there is one.
+func (f *Finisher) clickURL(fin *finishing, folder *bindings.Folder, creative *bindings.Creative, bid *rtb_types.Bid, price float64) (string, error) {
+	t := &bindings.ClickToken{
+		SaleID:     int(bid.ID),
+		SSPID:      fin.ssp,
+		FolderID:   folder.ID,
+		CreativeID: creative.ID,
+		Country:    fin.request.Device.Geo.Country,
+		Placement:  fin.request.Site.Placement,
+		Price:      price,
+	}
+	if len(fin.request.Site.Keywords) > 0 {
+		t.Keyword = fin.request.Site.Keywords[0]
+	}
+	if f.Clicks == nil {
+		return creative.Expand(t.Macros("")), nil
+	}
+	return f.Clicks.URL(t)
+}
+
+// NoBid answers with a 204, counting why.
+func (f *Finisher) NoBid(w http.ResponseWriter, ssp int, reason string) {
+	f.Counters.Inc(ssp, "finish_"+reason)
+	w.Header().Set("X-Nobid-Reason", reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// log writes to the bid log without holding up the bid when it's backed up.
+func (f *Finisher) log(line string) {
+	select {
+	case f.Messages <- line:
+	default:
+		f.Counters.Inc(0, "bid_log_dropped")
+	}
+}
+
+func holds(ids []int, creativeID int) bool {
+	for _, id := range ids {
+		if id == creativeID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,291 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/pricing"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fixedCTR float64
+
+func (f fixedCTR) PredictCTR(*pricing.Context) (float64, bool) {
+	return float64(f), true
+}
+
+// bidOn is a bid the bidder made on a folder and creative.
+func bidOn(id int64, folderID, creativeID int) rtb_types.Bid {
+	return rtb_types.Bid{ID: id, Price: 1, Ext: &rtb_types.BidExt{FolderID: folderID, CreativeID: creativeID}}
+}
+
+// testFinisher makes the bids, whatever the request. Creative 1 is in folders 10 and 11 (and the
+// inactive 12), creative 2 is in no folder.
+func testFinisher(bids ...rtb_types.Bid) (*Finisher, *services.Counters) {
+	config := &Config{
+		folders: bindings.Folders{
+			{ID: 10, Active: true, CPC: 500, Creative: []int{1}},
+			{ID: 11, Active: true, CPC: 800, Creative: []int{1}, Modifiers: []*bindings.BidModifier{{Type: "Country", Value: 3, Multiplier: 1.5}}},
+			{ID: 12, Active: false, CPC: 2000, Creative: []int{1}},
+		},
+		creatives: bindings.Creatives{
+			{ID: 1, Active: true, RedirectUrl: "http://adv.com/1", Categories: []string{"IAB7-1"}, Domains: []string{"adv.com"}},
+			{ID: 2, Active: true, RedirectUrl: "http://adv.com/2"},
+		},
+		names: &bindings.Pseudonyms{Countries: map[string]int{"CA": 3}},
+	}
+	counters := &services.Counters{}
+	f := &Finisher{
+		Config:   config,
+		SSPs:     &SSPs{},
+		FX:       &bindings.FXRates{Home: "USD", Rates: map[string]float64{"EUR": 0.5}},
+		Pricer:   &pricing.CPMPricer{Models: []pricing.CTRModel{fixedCTR(0.01)}},
+		Counters: counters,
+		Messages: make(chan string, 10),
+	}
+	f.Next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&rtb_types.Response{SeatBids: []rtb_types.SeatBid{{Bids: bids}}})
+	})
+	return f, counters
+}
+
+func TestFinisherPrices(t *testing.T) {
+	f, counters := testFinisher(bidOn(5, 11, 1), bidOn(6, 10, 2), bidOn(7, 10, 1), rtb_types.Bid{ID: 8, Price: 1, URL: "http://adv.com/1"})
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("POST", "/7", strings.NewReader(`{"imp":[{"bidfloorcur":"EUR"}],"device":{"geo":{"country":"CA"}}}`)))
+
+	var resp rtb_types.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf(`got %d %s`, w.Code, w.Body.String())
+	}
+	// folder 11 is 800 x 1.5 for canada, at a 1% ctr 12000 usd or 6000 eur, and the bid the bidder
+	// made on folder 10 for the same creative stays there, 500 at 1% is 5000 usd or 2500 eur
+	bids := resp.SeatBids[0].Bids
+	if len(bids) != 2 || bids[0].ID != 5 || bids[0].Price != 6000 || bids[1].ID != 7 || bids[1].Price != 2500 || resp.Currency != "EUR" {
+		t.Errorf(`expected bids 5 at 6000 and 7 at 2500 EUR, got %+v in %s`, bids, resp.Currency)
+	}
+	if bids[0].Ext != nil || strings.Contains(w.Body.String(), `"ext"`) {
+		t.Error("the bidder's ext was sent on")
+	}
+	if counters.Get(7, "finish_no_folder") != 1 || counters.Get(7, "finish_no_ext") != 1 {
+		t.Error("the bids without a folder or ext weren't counted")
+	}
+	if line := <-f.Messages; !strings.Contains(line, "folder 11") || !strings.Contains(line, "cpc 800 x Country 3 1.500 = 1200.000") {
+		t.Errorf(`bid log has %q`, line)
+	}
+}
+
+func TestFinisherNoBids(t *testing.T) {
+	f, _ := testFinisher(rtb_types.Bid{Price: 1, URL: "http://adv.com/2"})
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("POST", "/7", strings.NewReader(`{}`)))
+	if w.Code != http.StatusNoContent {
+		t.Errorf(`a response without finished bids got %d`, w.Code)
+	}
+
+	// url method bids aren't touched
+	f, _ = testFinisher(rtb_types.Bid{Price: 1, URL: "http://adv.com/2"})
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/7?url=x", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"price":1`) {
+		t.Errorf(`url method response changed to %d %s`, w.Code, w.Body.String())
+	}
+}
+
+type clickURLs []*bindings.ClickToken
+
+func (c *clickURLs) URL(t *bindings.ClickToken) (string, error) {
+	*c = append(*c, t)
+	return "http://dsp.com/click", nil
+}
+
+type failingClickURLs struct{}
+
+func (failingClickURLs) URL(*bindings.ClickToken) (string, error) {
+	return "", errors.New("can't seal")
+}
+
+func TestFinisherClickURLs(t *testing.T) {
+	dest := "http://adv.com/1?kw={keyword}&c={country}"
+	f, _ := testFinisher(bidOn(5, 11, 1))
+	f.Config.creatives[0].RedirectUrl = dest
+	serve := func() string {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("POST", "/7", strings.NewReader(`{"imp":[{}],"site":{"keywords":["red cars","blue"]},"device":{"geo":{"country":"CA"}}}`)))
+		var resp rtb_types.Response
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.SeatBids) != 1 || len(resp.SeatBids[0].Bids) != 1 {
+			t.Fatalf(`got %d %s`, w.Code, w.Body.String())
+		}
+		return resp.SeatBids[0].Bids[0].URL
+	}
+
+	if rurl := serve(); rurl != "http://adv.com/1?kw=red+cars&c=CA" {
+		t.Errorf(`without click tracking the rurl is %q`, rurl)
+	}
+	clicks := &clickURLs{}
+	f.Clicks = clicks
+	if rurl := serve(); rurl != "http://dsp.com/click" || len(*clicks) != 1 {
+		t.Fatalf(`with click tracking the rurl is %q`, rurl)
+	}
+	if tok := (*clicks)[0]; tok.SaleID != 5 || tok.SSPID != 7 || tok.FolderID != 11 || tok.CreativeID != 1 || tok.Keyword != "red cars" || tok.Price != 12000 {
+		t.Errorf(`click token is %+v`, tok)
+	}
+}
+
+func TestFinisherRotates(t *testing.T) {
+	f, _ := testFinisher(bidOn(5, 11, 1))
+	f.Config.folders[1].Creative, f.Config.folders[1].Weights = []int{1, 3}, []float64{1, 1}
+	f.Config.creatives = append(f.Config.creatives, &bindings.Creative{ID: 3, Active: true, RedirectUrl: "http://adv.com/3"})
+	f.Rotation = &bindings.Rotation{}
+	serve := func(body string) string {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("POST", "/7", strings.NewReader(body)))
+		var resp rtb_types.Response
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.SeatBids) != 1 || len(resp.SeatBids[0].Bids) != 1 {
+			t.Fatalf(`got %d %s`, w.Code, w.Body.String())
+		}
+		return resp.SeatBids[0].Bids[0].URL
+	}
+
+	// creative 1 is blocked, so folder 11 can only show creative 3
+	if rurl := serve(`{"imp":[{}],"bcat":["IAB7"]}`); rurl != "http://adv.com/3" {
+		t.Errorf(`expected creative 3 to be shown, got %q`, rurl)
+	}
+	shown := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		shown[serve(`{"imp":[{}]}`)] = true
+	}
+	if len(shown) != 2 {
+		t.Errorf(`rotation showed %v`, shown)
+	}
+}
+
+func TestFinisherBlocks(t *testing.T) {
+	f, counters := testFinisher(bidOn(5, 11, 1))
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("POST", "/7", strings.NewReader(`{"imp":[{}],"bcat":["IAB7"]}`)))
+	if w.Code != http.StatusNoContent || counters.Get(7, "blocked_bcat") != 1 || counters.Get(7, "finish_blocked") != 1 {
+		t.Errorf(`a bid on a blocked category got %d`, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("POST", "/7", strings.NewReader(`{"imp":[{}],"bcat":["IAB8"]}`)))
+	var resp rtb_types.Response
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if bids := resp.SeatBids; len(bids) != 1 || len(bids[0].Bids) != 1 || len(bids[0].Bids[0].Categories) != 1 || bids[0].Bids[0].Domains[0] != "adv.com" {
+		t.Errorf(`the bid wasn't described, got %s`, w.Body.String())
+	}
+}
+
+func TestFinisherShades(t *testing.T) {
+	f, _ := testFinisher(bidOn(5, 11, 1))
+	f.Shader = &pricing.Shader{}
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("POST", "/7", strings.NewReader(`{"imp":[{}]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf(`got %d`, w.Code)
+	}
+	if !f.Shader.Won(5) {
+		t.Error("the shader wasn't told of the bid")
+	}
+
+	// a bid dropped after it's shaded isn't held
+	f.Clicks = failingClickURLs{}
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/7", strings.NewReader(`{"imp":[{}]}`)))
+	if f.Shader.Won(5) {
+		t.Error("the shader was told of a dropped bid")
+	}
+}
+
+func TestFinisherLate(t *testing.T) {
+	f, counters := testFinisher(bidOn(5, 11, 1))
+	f.Shader, f.Wins = &pricing.Shader{}, &Wins{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("POST", "/7", strings.NewReader(`{"imp":[{}]}`)).WithContext(ctx))
+	if w.Code != http.StatusNoContent || counters.Get(7, "finish_deadline") != 1 {
+		t.Errorf(`a bid after the deadline got %d`, w.Code)
+	}
+	if f.Shader.Won(5) || f.Wins.won(5) != nil {
+		t.Error("a bid that was never sent was held")
+	}
+}
+
+func TestFinisherDeals(t *testing.T) {
+	f, counters := testFinisher(bidOn(5, 11, 1))
+	f.SSPs.users = bindings.Users{{ID: 7, Deals: bindings.Deals{{ID: "d1", Priority: 1}}}}
+	serve := func(body string) (*rtb_types.Response, int) {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("POST", "/7", strings.NewReader(body)))
+		resp := &rtb_types.Response{}
+		json.Unmarshal(w.Body.Bytes(), resp)
+		return resp, w.Code
+	}
+
+	// folder 11 bids 8000 usd, under the deal's floor of 5000 eur (10000 usd), and the deal's
+	// floor of 4000 eur takes the place of the open auction's
+	if _, code := serve(`{"imp":[{"bidfloor":5000,"bidfloorcur":"EUR","pmp":{"deals":[{"id":"d1","bidfloor":5000,"bidfloorcur":"EUR"}]}}]}`); code != http.StatusNoContent || counters.Get(7, "finish_below_floor") != 1 {
+		t.Errorf(`a bid under the deal's floor got %d`, code)
+	}
+	resp, code := serve(`{"imp":[{"bidfloor":5000,"bidfloorcur":"EUR","pmp":{"deals":[{"id":"d1","bidfloor":4000,"bidfloorcur":"EUR"}]}}]}`)
+	if code != http.StatusOK || resp.SeatBids[0].Bids[0].DealID != "d1" {
+		t.Errorf(`expected a bid on deal d1, got %d %+v`, code, resp)
+	}
+	if _, code := serve(`{"imp":[{"pmp":{"private_auction":1,"deals":[{"id":"other"}]}}]}`); code != http.StatusNoContent || counters.Get(7, "finish_private_auction") != 1 {
+		t.Errorf(`bid on a private auction without a deal, got %d`, code)
+	}
+}
+
+func TestConfigDimensions(t *testing.T) {
+	c := &Config{names: &bindings.Pseudonyms{
+		Networks:    map[string]int{"net": 4},
+		OSes:        map[string]int{"ios": 6},
+		Browsers:    map[string]int{"safari": 2},
+		Subchannels: map[bindings.Subchannel]int{{ChannelID: 4, Label: "news"}: 9, {ChannelID: 5, Label: "news"}: 8},
+	}}
+	r := &rtb_types.Request{}
+	r.Site.Network, r.Site.Subchannel = "Net", "News"
+	r.Device.OS, r.Device.Browser = "iOS", "safari"
+	d := c.Dimensions(r)
+	if d.NetworkID != 4 || d.SubchannelID != 9 {
+		t.Errorf(`resolved network %d subchannel %d`, d.NetworkID, d.SubchannelID)
+	}
+	if d.OSID != 6 || d.BrowserID != 2 {
+		t.Errorf(`resolved os %d browser %d`, d.OSID, d.BrowserID)
+	}
+}
+
+func TestConfigTaxonomy(t *testing.T) {
+	taxonomy := &bindings.Taxonomy{}
+	taxonomy.Add("cars", 1, 10, 1)
+	c := &Config{names: &bindings.Pseudonyms{Verticals: map[string]int{"finance": 2}}, taxonomy: taxonomy}
+
+	r := &rtb_types.Request{}
+	r.Site.Placement = "http://example.com/cars"
+	if d := c.Dimensions(r); d.VerticalID != 1 || d.InterestID != 10 {
+		t.Errorf(`classified as vertical %d interest %d`, d.VerticalID, d.InterestID)
+	}
+	r.Site.Vertical = "finance"
+	if d := c.Dimensions(r); d.VerticalID != 2 || d.InterestID != 10 {
+		t.Errorf(`the ssp's vertical should win, got %d`, d.VerticalID)
+	}
+	if r.Site.Vertical != "finance" || r.User.Interest != "" {
+		t.Error("classifying changed the request")
+	}
+
+	for _, bad := range []float64{-0.1, 1.5} {
+		if _, err := (&Config{Threshold: bad}).threshold(); err == nil {
+			t.Errorf(`accepted a threshold of %f`, bad)
+		}
+	}
+	if th, err := (&Config{Threshold: 0.8}).threshold(); th != 0.8 || err != nil {
+		t.Errorf(`got threshold %f, %v`, th, err)
+	}
+}
@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Enricher fills in request fields the ssp left out, before the bidder sees the request. It
+// returns whether it changed anything.
+type Enricher interface {
+	Enrich(*rtb_types.Request) bool
+}
+
+// Filter decides a request shouldn't reach the bidder, returning the no-bid reason, or "" to let
+// it through.
+type Filter interface {
+	Check(ssp int, r *rtb_types.Request) string
+}
+
+// BidGate sits in front of the bid entrypoint. It decodes requests once, answers those a filter
+// rejects with a 204 (counting the reason against the ssp), runs the rest through the enrichers
+// and hands the (possibly rewritten) body on to Next. Bodies it can't decode are passed on
+// untouched. Url method requests are filtered on their query but never rewritten.
+type BidGate struct {
+	Next      http.Handler
+	Filters   []Filter
+	Enrichers []Enricher
+	Counters  *services.Counters
+	Messages  chan string
+}
+
+func (g *BidGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ssp := SSPID(r)
+	g.Counters.Inc(ssp, "requests")
+
+	if r.Method != "POST" {
+		req := requestFromQuery(r.URL.Query())
+		for _, f := range g.Filters {
+			if reason := f.Check(ssp, req); reason != "" {
+				g.NoBid(w, ssp, reason)
+				return
+			}
+		}
+		g.Next.ServeHTTP(w, r)
+		return
+	}
+
+	b := bytes.NewBuffer(nil)
+	if _, err := b.ReadFrom(r.Body); err != nil {
+		w.WriteHeader(500)
+		g.Messages <- "gate failed to read because " + err.Error()
+		return
+	}
+	r.Body.Close()
+	body := b.Bytes()
+
+	req := &rtb_types.Request{}
+	if err := json.Unmarshal(body, req); err == nil {
+		for _, f := range g.Filters {
+			if reason := f.Check(ssp, req); reason != "" {
+				g.NoBid(w, ssp, reason)
+				return
+			}
+		}
+		changed := false
+		for _, e := range g.Enrichers {
+			if e.Enrich(req) {
+				changed = true
+			}
+		}
+		if changed {
+			if js, err := json.Marshal(req); err == nil {
+				body = js
+			}
+		}
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	g.Next.ServeHTTP(w, r)
+}
+
+// NoBid answers with a 204, recording why.
+func (g *BidGate) NoBid(w http.ResponseWriter, ssp int, reason string) {
+	g.Counters.Inc(ssp, reason)
+	w.Header().Set("X-Nobid-Reason", reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestFromQuery reads the macros of the url method into a request.
+func requestFromQuery(q url.Values) *rtb_types.Request {
+	req := &rtb_types.Request{}
+	req.Device.UserAgent = q.Get("ua")
+	req.User.RemoteAddr = q.Get("ip")
+	req.Site.Placement = q.Get("url")
+	req.Test = q.Get("test") == "true"
+	if kw := q.Get("kwords"); kw != "" {
+		req.Site.Keywords = strings.Split(kw, ",")
+	}
+	return req
+}
+
+// SSPID reads the ssp from a bid url (/{sspid}), or the ssp query parameter.
+func SSPID(r *http.Request) int {
+	if id, err := strconv.Atoi(strings.Trim(r.URL.Path, "/")); err == nil {
+		return id
+	}
+	id, _ := strconv.Atoi(r.URL.Query().Get("ssp"))
+	return id
+}
@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type GeoRecord struct {
+	Country string
+	Region  string
+	City    string
+}
+
+// GeoIP locates requests the ssp didn't geolocate, from the MaxMind format database at Path
+// (TGEOIPDB if unset). The database is reopened whenever the file's modification time changes, and
+// the reader it replaces is closed once the lookups using it are done.
+type GeoIP struct {
+	Path     string
+	Interval time.Duration
+	Messages chan string
+
+	lock    sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+}
+
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+func (g *GeoIP) Cycle(quit func(error) bool) {
+	g.lock.Lock()
+	if g.Path == "" {
+		g.Path = os.Getenv("TGEOIPDB")
+	}
+	g.lock.Unlock()
+	quit(g.reload())
+}
+
+func (g *GeoIP) Launch(errs chan error) error {
+	g.Messages <- "launching geoip watcher"
+	if g.Interval == 0 {
+		g.Interval = time.Minute
+	}
+	go func() {
+		for range time.NewTicker(g.Interval).C {
+			if err := g.reload(); err != nil {
+				errs <- err
+			}
+		}
+	}()
+	return nil
+}
+
+// reload reopens the database if it changed, keeping the current one if it can't.
+func (g *GeoIP) reload() error {
+	g.lock.RLock()
+	path, modTime := g.Path, g.modTime
+	g.lock.RUnlock()
+	if path == "" {
+		return nil
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return services.ErrDatabaseMissing{Name: "geoip " + path, UnderlyingErr: err}
+	}
+	if st.ModTime().Equal(modTime) {
+		return nil
+	}
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return services.ErrDatabaseMissing{Name: "geoip " + path, UnderlyingErr: err}
+	}
+	// lookups hold the read lock while they use the reader, so once it's swapped nothing is using
+	// the old one
+	g.lock.Lock()
+	old := g.reader
+	g.reader, g.modTime = reader, st.ModTime()
+	g.lock.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	g.Messages <- "geoip loaded " + path + " built " + time.Unix(int64(reader.Metadata.BuildEpoch), 0).String()
+	return nil
+}
+
+// Close closes the database, lookups find nothing until it's reloaded.
+func (g *GeoIP) Close() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	reader := g.reader
+	g.reader, g.modTime = nil, time.Time{}
+	if reader == nil {
+		return nil
+	}
+	return reader.Close()
+}
+
+func (g *GeoIP) Lookup(addr string) (GeoRecord, bool) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return GeoRecord{}, false
+	}
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	if g.reader == nil {
+		return GeoRecord{}, false
+	}
+
+	var rec mmdbRecord
+	if err := g.reader.Lookup(ip, &rec); err != nil {
+		return GeoRecord{}, false
+	}
+	geo := GeoRecord{Country: rec.Country.ISOCode, City: rec.City.Names["en"]}
+	if len(rec.Subdivisions) > 0 {
+		geo.Region = rec.Subdivisions[0].ISOCode
+	}
+	return geo, geo.Country != ""
+}
+
+// Enrich fills the country, region and city the ssp left empty. The region and city are only
+// taken when the country agrees with any the ssp sent.
+func (g *GeoIP) Enrich(r *rtb_types.Request) bool {
+	geo := &r.Device.Geo
+	if geo.Country != "" && geo.Region != "" && geo.City != "" {
+		return false
+	}
+	found, ok := g.Lookup(r.User.RemoteAddr)
+	if !ok {
+		return false
+	}
+	return fillGeo(r, found)
+}
+
+func fillGeo(r *rtb_types.Request, found GeoRecord) bool {
+	geo := &r.Device.Geo
+	if geo.Country != "" && !strings.EqualFold(geo.Country, found.Country) {
+		return false
+	}
+	changed := false
+	if geo.Country == "" {
+		geo.Country, changed = found.Country, true
+	}
+	if geo.Region == "" && found.Region != "" {
+		geo.Region, changed = found.Region, true
+	}
+	if geo.City == "" && found.City != "" {
+		geo.City, changed = found.City, true
+	}
+	return changed
+}
@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFillGeo(t *testing.T) {
+	found := GeoRecord{Country: "US", Region: "CA", City: "San Francisco"}
+
+	r := &rtb_types.Request{}
+	if !fillGeo(r, found) || r.Device.Geo.Country != "US" || r.Device.Geo.City != "San Francisco" {
+		t.Errorf(`didn't fill an empty geo, got %+v`, r.Device.Geo)
+	}
+
+	r = &rtb_types.Request{}
+	r.Device.Geo.Country = "us"
+	if !fillGeo(r, found) || r.Device.Geo.Country != "us" || r.Device.Geo.Region != "CA" {
+		t.Errorf(`a lowercase country should still agree, got %+v`, r.Device.Geo)
+	}
+
+	r = &rtb_types.Request{}
+	r.Device.Geo.Country = "GB"
+	if fillGeo(r, found) || r.Device.Geo.Region != "" {
+		t.Errorf(`took the region of another country, got %+v`, r.Device.Geo)
+	}
+}
+
+func TestGeoIPReload(t *testing.T) {
+	f, err := ioutil.TempFile("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("not a maxmind database")
+	f.Close()
+
+	g := &GeoIP{Path: f.Name(), Messages: make(chan string, 10)}
+	if err := g.reload(); err == nil {
+		t.Error("opened a file that isn't a database")
+	}
+	g.Path = f.Name() + ".missing"
+	if err := g.reload(); err == nil {
+		t.Error("no error for a missing database")
+	}
+	if _, ok := g.Lookup("1.2.3.4"); ok {
+		t.Error("found a location without a database")
+	}
+}
+
+// writeCountryDB writes a MaxMind format database placing every ipv4 address in country.
+func writeCountryDB(t *testing.T, path, country string) {
+	str := func(s string) []byte { return append([]byte{0x40 | byte(len(s))}, s...) }
+	var db []byte
+	// one node, both records pointing at the only record in the data section
+	db = append(db, 0, 0, 17, 0, 0, 17)
+	db = append(db, make([]byte, 16)...)
+	db = append(db, 0xe1)
+	db = append(db, str("country")...)
+	db = append(db, 0xe1)
+	db = append(db, str("iso_code")...)
+	db = append(db, str(country)...)
+	db = append(db, "\xab\xcd\xefMaxMind.com"...)
+	db = append(db, 0xe6)
+	db = append(db, str("node_count")...)
+	db = append(db, 0xc1, 1)
+	db = append(db, str("record_size")...)
+	db = append(db, 0xa1, 24)
+	db = append(db, str("ip_version")...)
+	db = append(db, 0xa1, 4)
+	db = append(db, str("database_type")...)
+	db = append(db, str("Test")...)
+	db = append(db, str("binary_format_major_version")...)
+	db = append(db, 0xa1, 2)
+	db = append(db, str("binary_format_minor_version")...)
+	db = append(db, 0xa0)
+	if err := ioutil.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeoIPSwap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/geo.mmdb"
+	writeCountryDB(t, path, "US")
+
+	g := &GeoIP{Path: path, Messages: make(chan string, 10)}
+	if err := g.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if geo, ok := g.Lookup("1.2.3.4"); !ok || geo.Country != "US" {
+		t.Fatalf(`found %+v`, geo)
+	}
+
+	// the replaced reader is closed as soon as it's swapped out
+	old := g.reader
+	writeCountryDB(t, path, "CA")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if err := g.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if geo, ok := g.Lookup("1.2.3.4"); !ok || geo.Country != "CA" {
+		t.Errorf(`after the reload found %+v`, geo)
+	}
+	var rec mmdbRecord
+	if old.Lookup([]byte{1, 2, 3, 4}, &rec) == nil {
+		t.Error("the replaced reader is still open")
+	}
+
+	g.Close()
+	if _, ok := g.Lookup("1.2.3.4"); ok {
+		t.Error("found a location after closing")
+	}
+}
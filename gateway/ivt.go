@@ -0,0 +1,210 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"gopkg.in/redis.v5"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NoBidDatacenter = "ivt_datacenter"
+	NoBidBot        = "ivt_bot"
+	NoBidRate       = "ivt_rate"
+)
+
+// IVT turns away invalid traffic before it's bid on: requests from datacenter ip ranges, user
+// agents matching known bots, and ips or muids sending more than MaxPerMinute requests. The
+// ranges (TIVTDATACENTERS) and bot patterns (TIVTBOTS) are files of one entry per line, reread
+// each cycle. Request rates are counted in KVS, and aren't checked without it.
+type IVT struct {
+	DatacenterPath string
+	BotPath        string
+	MaxPerMinute   int64
+	KVS            *redis.Client
+
+	lock        sync.RWMutex
+	datacenters *CIDRSet
+	bots        []string
+}
+
+func (v *IVT) Cycle(quit func(error) bool) {
+	if v.DatacenterPath == "" {
+		v.DatacenterPath = os.Getenv("TIVTDATACENTERS")
+	}
+	if v.BotPath == "" {
+		v.BotPath = os.Getenv("TIVTBOTS")
+	}
+	if v.MaxPerMinute == 0 {
+		v.MaxPerMinute = 60
+	}
+
+	// a file that didn't read completely would let through traffic the last one turned away, so
+	// keep the last lists until these read; a bad line is only skipped
+	datacenters := &CIDRSet{}
+	if v.DatacenterPath != "" {
+		lines, err := readLines(v.DatacenterPath)
+		if err != nil {
+			quit(services.ErrDatabaseMissing{Name: "ivt datacenters " + v.DatacenterPath, UnderlyingErr: err})
+			return
+		}
+		for _, line := range lines {
+			if err := datacenters.Add(line); err != nil && quit(services.ErrParsing{What: "ivt datacenter " + line, UnderlyingErr: err}) {
+				return
+			}
+		}
+		datacenters.Compact()
+	}
+
+	var bots []string
+	if v.BotPath != "" {
+		lines, err := readLines(v.BotPath)
+		if err != nil {
+			quit(services.ErrDatabaseMissing{Name: "ivt bots " + v.BotPath, UnderlyingErr: err})
+			return
+		}
+		for _, line := range lines {
+			bots = append(bots, strings.ToLower(line))
+		}
+	}
+
+	v.lock.Lock()
+	v.datacenters, v.bots = datacenters, bots
+	v.lock.Unlock()
+}
+
+func (v *IVT) Check(ssp int, r *rtb_types.Request) string {
+	v.lock.RLock()
+	datacenters, bots := v.datacenters, v.bots
+	v.lock.RUnlock()
+
+	if datacenters.Contains(r.User.RemoteAddr) {
+		return NoBidDatacenter
+	}
+	if ua := strings.ToLower(r.Device.UserAgent); ua != "" {
+		for _, bot := range bots {
+			if strings.Contains(ua, bot) {
+				return NoBidBot
+			}
+		}
+	}
+	if v.overRate("ip", r.User.RemoteAddr) || v.overRate("muid", r.User.MostUniqueID) {
+		return NoBidRate
+	}
+	return ""
+}
+
+// overRate counts a request against the current minute, failing open if redis is unavailable.
+func (v *IVT) overRate(kind, id string) bool {
+	if v.KVS == nil || id == "" {
+		return false
+	}
+	key := fmt.Sprintf(`ivt:%s:%s:%d`, kind, id, time.Now().Unix()/60)
+	n, err := v.KVS.Incr(key).Result()
+	if err != nil {
+		return false
+	}
+	if n == 1 {
+		v.KVS.Expire(key, 2*time.Minute)
+	}
+	return n > v.MaxPerMinute
+}
+
+// readLines returns the non-empty lines of a file, without # comments.
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, sc.Err()
+}
+
+type ipRange struct {
+	start, end net.IP
+}
+
+// CIDRSet holds ip ranges for fast lookups. Add plain ips or CIDRs, then Compact before use.
+type CIDRSet struct {
+	ranges []ipRange
+}
+
+func (s *CIDRSet) Add(entry string) error {
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return fmt.Errorf(`not an ip: %s`, entry)
+		}
+		ip = ip.To16()
+		s.ranges = append(s.ranges, ipRange{ip, ip})
+		return nil
+	}
+	_, n, err := net.ParseCIDR(entry)
+	if err != nil {
+		return err
+	}
+	start := n.IP.To16()
+	end := make(net.IP, len(start))
+	copy(end, start)
+	ones, bits := n.Mask.Size()
+	for i := ones + (128 - bits); i < 128; i++ {
+		end[i/8] |= 1 << uint(7-i%8)
+	}
+	s.ranges = append(s.ranges, ipRange{start, end})
+	return nil
+}
+
+// Compact sorts the ranges and merges any that overlap.
+func (s *CIDRSet) Compact() {
+	sort.Slice(s.ranges, func(i, j int) bool { return bytes.Compare(s.ranges[i].start, s.ranges[j].start) < 0 })
+	merged := s.ranges[:0]
+	for _, r := range s.ranges {
+		if n := len(merged); n > 0 && bytes.Compare(r.start, merged[n-1].end) <= 0 {
+			if bytes.Compare(r.end, merged[n-1].end) > 0 {
+				merged[n-1].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	s.ranges = merged
+}
+
+func (s *CIDRSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.ranges)
+}
+
+func (s *CIDRSet) Contains(addr string) bool {
+	if s.Len() == 0 {
+		return false
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	ip = ip.To16()
+	i := sort.Search(len(s.ranges), func(i int) bool { return bytes.Compare(s.ranges[i].end, ip) >= 0 })
+	return i < len(s.ranges) && bytes.Compare(s.ranges[i].start, ip) <= 0
+}
@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"bytes"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCIDRSet(t *testing.T) {
+	s := &CIDRSet{}
+	for _, e := range []string{"10.0.0.0/8", "192.168.1.7", "10.1.0.0/16", "2001:db8::/32"} {
+		if err := s.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Compact()
+	if s.Len() != 3 {
+		t.Errorf(`expected overlapping ranges to merge, have %d`, s.Len())
+	}
+	for addr, want := range map[string]bool{
+		"10.200.3.4":    true,
+		"11.0.0.0":      false,
+		"192.168.1.7":   true,
+		"192.168.1.8":   false,
+		"2001:db8::1":   true,
+		"2001:db9::1":   false,
+		"not an ip":     false,
+		"9.255.255.255": false,
+	} {
+		if got := s.Contains(addr); got != want {
+			t.Errorf(`%s: got %v want %v`, addr, got, want)
+		}
+	}
+}
+
+func TestGateFiltersIVT(t *testing.T) {
+	ivt := &IVT{datacenters: &CIDRSet{}, bots: []string{"headlesschrome"}}
+	ivt.datacenters.Add("10.0.0.0/8")
+	ivt.datacenters.Compact()
+
+	passed := 0
+	counters := &services.Counters{}
+	g := &BidGate{Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { passed++ }), Filters: []Filter{ivt}, Counters: counters}
+
+	for _, body := range []string{
+		`{"device":{"ua":"Mozilla/5.0 HeadlessChrome/90.0"},"user":{"remoteaddr":"8.8.8.8"}}`,
+		`{"device":{"ua":"Mozilla/5.0"},"user":{"remoteaddr":"10.0.0.1"}}`,
+		`{"device":{"ua":"Mozilla/5.0"},"user":{"remoteaddr":"8.8.8.8"}}`,
+	} {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest("POST", "/7", bytes.NewBufferString(body)))
+		t.Log(w.Code, w.Header().Get("X-Nobid-Reason"))
+	}
+	if passed != 1 || counters.Get(7, NoBidBot) != 1 || counters.Get(7, NoBidDatacenter) != 1 || counters.Get(7, "requests") != 3 {
+		t.Errorf(`unexpected passed %d, counters %s`, passed, counters)
+	}
+}
+
+func TestIVTKeepLastOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ivt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	datacenters, bots := filepath.Join(dir, "datacenters"), filepath.Join(dir, "bots")
+	ioutil.WriteFile(datacenters, []byte("10.0.0.0/8\nnot a range\n"), 0644)
+	ioutil.WriteFile(bots, []byte("HeadlessChrome\n"), 0644)
+
+	quits := 0
+	quit := func(err error) bool {
+		quits++
+		return false
+	}
+	v := &IVT{DatacenterPath: datacenters, BotPath: bots}
+	check := func(ua, addr string) string {
+		r := &rtb_types.Request{}
+		r.Device.UserAgent, r.User.RemoteAddr = ua, addr
+		return v.Check(1, r)
+	}
+	v.Cycle(quit)
+	if quits != 1 || check("", "10.0.0.1") != NoBidDatacenter {
+		t.Fatalf(`the bad line wasn't skipped, %d quits`, quits)
+	}
+
+	// a list that can't be read keeps the last lists, even though quit said to carry on
+	os.Remove(bots)
+	v.Cycle(quit)
+	if quits != 3 || check("headlesschrome", "8.8.8.8") != NoBidBot || check("", "10.0.0.1") != NoBidDatacenter {
+		t.Errorf(`the last lists were dropped, %d quits`, quits)
+	}
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/services"
+	"sync"
+	"time"
+)
+
+// PurchaseSaver stores purchases rows, bindings.Purchases in production.
+type PurchaseSaver interface {
+	SaveAll(fs []*bindings.Purchase, quit func(error) bool)
+}
+
+// Purchases records a purchases row for each won bid, its money split by Revenue. Win is a Wins
+// hook; rows are queued and Launch saves them in batches, so a slow stats db never holds up a win
+// notice. A row that doesn't fit the queue (QueueSize, 10000 by default) is dropped, counted as
+// purchase_dropped.
+type Purchases struct {
+	Saver     PurchaseSaver
+	Revenue   bindings.Revenue
+	SSPs      *SSPs
+	QueueSize int
+	Counters  *services.Counters
+	Messages  chan string
+
+	once  sync.Once
+	queue chan *bindings.Purchase
+}
+
+func (ps *Purchases) queued() chan *bindings.Purchase {
+	ps.once.Do(func() {
+		if ps.QueueSize == 0 {
+			ps.QueueSize = 10000
+		}
+		ps.queue = make(chan *bindings.Purchase, ps.QueueSize)
+	})
+	return ps.queue
+}
+
+// Win takes the clearing price in wire units. Test bids aren't billable.
+func (ps *Purchases) Win(saleID int, b *HeldBid, price float64) {
+	ssp := ps.SSPs.ByID(b.SSPID)
+	if ssp == nil {
+		ssp = &bindings.User{ID: b.SSPID}
+	}
+	var deal *bindings.Deal
+	if b.DealID != "" {
+		deal = ssp.Deals.ByID(b.DealID)
+	}
+	p, err := ps.Revenue.Purchase(saleID, !b.Test, price, ssp, deal, b.FolderID, b.CreativeID, b.Placement, b.Dimensions)
+	if err != nil {
+		ps.Counters.Inc(b.SSPID, "purchase_failed")
+		ps.Messages <- "couldn't price purchase: " + err.Error()
+		return
+	}
+	select {
+	case ps.queued() <- p:
+	default:
+		ps.Counters.Inc(b.SSPID, "purchase_dropped")
+	}
+}
+
+// Flush saves up to limit queued rows, returning how many.
+func (ps *Purchases) Flush(limit int) int {
+	queue := ps.queued()
+	var batch []*bindings.Purchase
+	for len(batch) < limit && len(queue) > 0 {
+		batch = append(batch, <-queue)
+	}
+	if len(batch) > 0 {
+		ps.Saver.SaveAll(batch, ps.retry)
+	}
+	return len(batch)
+}
+
+// retry has SaveAll try again on any error, rows are money so they're not given up on lightly.
+func (ps *Purchases) retry(err error) bool {
+	if edm, ok := err.(services.ErrDatabaseMissing); err == nil || ok && edm.UnderlyingErr == nil {
+		return false
+	}
+	ps.Messages <- "couldn't save purchases: " + err.Error()
+	return true
+}
+
+func (ps *Purchases) Launch(errs chan error) error {
+	ps.Messages <- "launching purchases"
+	go func() {
+		for range time.NewTicker(time.Second).C {
+			for ps.Flush(100) == 100 {
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/pricing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type savedPurchases []*bindings.Purchase
+
+func (s *savedPurchases) SaveAll(fs []*bindings.Purchase, quit func(error) bool) {
+	*s = append(*s, fs...)
+}
+
+func TestPurchasesFromBidToRow(t *testing.T) {
+	f, counters := testFinisher(bidOn(5, 11, 1))
+	f.Config.names.Networks = map[string]int{"net": 4}
+	f.Config.names.Subchannels = map[bindings.Subchannel]int{{ChannelID: 4, Label: "news"}: 9}
+	f.SSPs.users = bindings.Users{{ID: 7, Currency: "EUR", Margin: 0.25}}
+
+	saved := &savedPurchases{}
+	ps := &Purchases{Saver: saved, Revenue: bindings.Revenue{FX: f.FX, Counters: counters}, SSPs: f.SSPs, Counters: counters, Messages: f.Messages}
+	ws := &Wins{Next: http.NotFoundHandler(), Shader: &pricing.Shader{}, Counters: counters}
+	ws.OnWin = append(ws.OnWin, ps.Win)
+	f.Wins = ws
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/7", strings.NewReader(`{"imp":[{}],"site":{"network":"Net","subchannel":"News","placement":"http://news.com/a"},"device":{"geo":{"country":"CA"}}}`)))
+	ws.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/win?ssp=7&key=5&price=4000", nil))
+	if n := ps.Flush(100); n != 1 || len(*saved) != 1 {
+		t.Fatalf(`flushed %d purchases`, n)
+	}
+
+	// 4000 eur, of which the ssp gets 75%, at 0.5 eur to the usd
+	p := (*saved)[0]
+	if p.SaleID != 5 || !p.Billable || p.SSPID != 7 || p.FolderID != 11 || p.CreativeID != 1 || p.Placement != "http://news.com/a" {
+		t.Errorf(`purchase is %+v`, p)
+	}
+	if p.Split != (bindings.Split{Tx: 4000, TxHome: 8000, SSP: 3000, SSPHome: 6000}) {
+		t.Errorf(`split is %+v`, p.Split)
+	}
+	if p.Dimensions.CountryID != 3 || p.Dimensions.NetworkID != 4 || p.Dimensions.SubchannelID != 9 {
+		t.Errorf(`dimensions are %+v`, p.Dimensions)
+	}
+
+	// a full queue drops the row rather than holding up the notice
+	ps = &Purchases{Saver: saved, Revenue: ps.Revenue, SSPs: f.SSPs, QueueSize: 1, Counters: counters, Messages: f.Messages}
+	ps.Win(6, &HeldBid{SSPID: 7}, 100)
+	ps.Win(7, &HeldBid{SSPID: 7}, 100)
+	if counters.Get(7, "purchase_dropped") != 1 {
+		t.Error("the row that didn't fit wasn't dropped")
+	}
+}
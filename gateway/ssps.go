@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"fmt"
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/services"
+	"sync"
+)
+
+// SSPs holds the ssp (user) settings the gateway enforces, reloaded from the config db each cycle.
+// AllowUnlisted lets in ssps that have no servers in ip_histories from anywhere.
+type SSPs struct {
+	BindingDeps   services.BindingDeps
+	AllowUnlisted bool
+
+	lock    sync.RWMutex
+	users   bindings.Users
+	servers map[int]*CIDRSet
+	all     *CIDRSet
+}
+
+func (s *SSPs) Cycle(quit func(error) bool) {
+	if s.BindingDeps.ConfigDB == nil {
+		return
+	}
+	// a failed load keeps the last ssps, dropping them would turn away all traffic
+	users := bindings.Users{}
+	if err := users.Unmarshal(0, s.BindingDeps); err != nil {
+		quit(services.ErrDatabaseMissing{Name: "ssps", UnderlyingErr: err})
+		return
+	}
+
+	servers := make(map[int]*CIDRSet)
+	all := &CIDRSet{}
+	for _, u := range users {
+		for _, p := range u.Problems {
+			if quit(services.ErrDatabaseMissing{Name: fmt.Sprintf(`ssp %d deals`, u.ID), UnderlyingErr: p}) {
+				return
+			}
+		}
+		set := &CIDRSet{}
+		for _, ip := range u.IPs {
+			if err := set.Add(ip); err != nil {
+				if quit(services.ErrParsing{What: fmt.Sprintf(`ssp %d ip`, u.ID), UnderlyingErr: err}) {
+					return
+				}
+				continue
+			}
+			all.Add(ip)
+		}
+		set.Compact()
+		servers[u.ID] = set
+	}
+	all.Compact()
+
+	s.lock.Lock()
+	s.users, s.servers, s.all = users, servers, all
+	s.lock.Unlock()
+}
+
+func (s *SSPs) ByID(id int) *bindings.User {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.users.ByID(id)
+}
+
+// Limits are the ssp's qps limit and priority, and the highest priority of any ssp.
+func (s *SSPs) Limits(ssp int) (qps, priority, top int) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, u := range s.users {
+		if u.Priority > top {
+			top = u.Priority
+		}
+		if u.ID == ssp {
+			qps, priority = u.QPS, u.Priority
+		}
+	}
+	return
+}
+
+// AuthKeys are the keys of every ssp that has one.
+func (s *SSPs) AuthKeys() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var keys []string
+	for _, u := range s.users {
+		if u.AuthKey != "" {
+			keys = append(keys, u.AuthKey)
+		}
+	}
+	return keys
+}
+
+// FromServer is whether addr is one of the ssp's servers. With no ssp (0) addr may be any ssp's
+// server. SSPs without any servers listed are refused, unless AllowUnlisted.
+func (s *SSPs) FromServer(ssp int, addr string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	set := s.all
+	if ssp != 0 {
+		set = s.servers[ssp]
+	}
+	if set.Len() == 0 {
+		return s.AllowUnlisted
+	}
+	return set.Contains(addr)
+}
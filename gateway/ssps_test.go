@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"database/sql"
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/services"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+// closedDB is a config db every query fails on.
+func closedDB(t *testing.T) services.BindingDeps {
+	db, err := sql.Open("mysql", "dsp@tcp(127.0.0.1:1)/dsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	return services.BindingDeps{ConfigDB: db, StatsDB: db, Debug: log.New(ioutil.Discard, "", 0)}
+}
+
+func TestSSPsKeepLastOnError(t *testing.T) {
+	s := &SSPs{users: bindings.Users{{ID: 7, AuthKey: "k", QPS: 10}}, servers: map[int]*CIDRSet{7: {}}, all: &CIDRSet{}}
+	s.servers[7].Add("5.5.5.0/24")
+	s.servers[7].Compact()
+	s.all.Add("5.5.5.0/24")
+	s.all.Compact()
+
+	s.BindingDeps = closedDB(t)
+	quits := 0
+	s.Cycle(func(err error) bool {
+		quits++
+		return false
+	})
+	if quits != 1 {
+		t.Errorf(`the failed load was reported %d times`, quits)
+	}
+	if s.ByID(7) == nil || !s.FromServer(7, "5.5.5.5") || len(s.AuthKeys()) != 1 {
+		t.Error("a failed load dropped the last ssps")
+	}
+	if qps, _, _ := s.Limits(7); qps != 10 {
+		t.Errorf(`limits lost, qps %d`, qps)
+	}
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/services"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Throttle sheds bid requests with a quick 204 before they cost us anything. Each ssp is held to
+// its QPS (a token bucket allowing a second's worth of burst, unlimited when 0), and the process to
+// MaxInFlight requests at once. Under load lower priority ssps are shed first: an ssp of priority
+// p is only let in while fewer than MaxInFlight*(p+1)/(top+1) requests are in flight, top being
+// the highest priority of any ssp.
+type Throttle struct {
+	Next        http.Handler
+	SSPs        *SSPs
+	MaxInFlight int64
+	Counters    *services.Counters
+
+	inFlight int64
+	lock     sync.Mutex
+	buckets  map[int]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (t *Throttle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ssp := SSPID(r)
+	qps, priority, top := t.SSPs.Limits(ssp)
+
+	if !t.take(ssp, qps) {
+		t.Counters.Inc(ssp, "shed_qps")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	n := atomic.AddInt64(&t.inFlight, 1)
+	defer atomic.AddInt64(&t.inFlight, -1)
+	if t.MaxInFlight > 0 && n > t.MaxInFlight*int64(priority+1)/int64(top+1) {
+		t.Counters.Inc(ssp, "shed_load")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	t.Next.ServeHTTP(w, r)
+}
+
+func (t *Throttle) InFlight() int64 {
+	return atomic.LoadInt64(&t.inFlight)
+}
+
+func (t *Throttle) take(ssp, qps int) bool {
+	if qps <= 0 {
+		return true
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.buckets == nil {
+		t.buckets = make(map[int]*bucket)
+	}
+	now := time.Now()
+	b := t.buckets[ssp]
+	if b == nil {
+		b = &bucket{tokens: float64(qps), last: now}
+		t.buckets[ssp] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * float64(qps)
+	if b.tokens > float64(qps) {
+		b.tokens = float64(qps)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestThrottleQPS(t *testing.T) {
+	ssps := &SSPs{users: bindings.Users{{ID: 1, QPS: 5}, {ID: 2}}}
+	counters := &services.Counters{}
+	th := &Throttle{Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), SSPs: ssps, Counters: counters}
+
+	served := func(path string, n int) int {
+		ok := 0
+		for i := 0; i < n; i++ {
+			w := httptest.NewRecorder()
+			th.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+			if w.Code == http.StatusOK {
+				ok++
+			}
+		}
+		return ok
+	}
+	// a second's worth of burst, then nothing until the bucket refills
+	if n := served("/1", 20); n != 5 {
+		t.Errorf(`let %d requests through a 5 qps limit`, n)
+	}
+	if counters.Get(1, "shed_qps") != 15 {
+		t.Errorf(`counted %d shed requests`, counters.Get(1, "shed_qps"))
+	}
+	if n := served("/2", 20); n != 20 {
+		t.Errorf(`shed %d requests of an unlimited ssp`, 20-n)
+	}
+}
+
+func TestThrottlePriority(t *testing.T) {
+	ssps := &SSPs{users: bindings.Users{{ID: 1, Priority: 0}, {ID: 2, Priority: 1}}}
+	counters := &services.Counters{}
+	th := &Throttle{Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), SSPs: ssps, MaxInFlight: 4, Counters: counters}
+	code := func(path string) int {
+		w := httptest.NewRecorder()
+		th.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		return w.Code
+	}
+
+	if code("/1") != http.StatusOK {
+		t.Error("shed a request with nothing in flight")
+	}
+	// with 2 of 4 already in flight, priority 0 (allowed half) is shed and priority 1 isn't
+	th.inFlight = 2
+	if low, high := code("/1"), code("/2"); low != http.StatusNoContent || high != http.StatusOK {
+		t.Errorf(`under load got %d for the low priority ssp and %d for the high`, low, high)
+	}
+	if counters.Get(1, "shed_load") != 1 || counters.Get(2, "shed_load") != 0 {
+		t.Error("shed the wrong ssp")
+	}
+	if th.InFlight() != 2 {
+		t.Errorf(`%d requests in flight after they finished, expected 2`, th.InFlight())
+	}
+}
@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"strings"
+)
+
+// UserAgent is what can be told about a device from its user agent. Empty fields are unknown.
+type UserAgent struct {
+	DeviceType string
+	OS         string
+	OSVersion  string
+	Browser    string
+}
+
+var osRules = []struct {
+	marker, os, version string
+}{
+	{"Windows Phone", "windows phone", "Windows Phone "},
+	{"Android", "android", "Android "},
+	{"iPhone", "ios", " OS "},
+	{"iPad", "ios", " OS "},
+	{"iPod", "ios", " OS "},
+	{"CrOS", "chromeos", ""},
+	{"Mac OS X", "macos", "Mac OS X "},
+	{"Windows NT", "windows", "Windows NT "},
+	{"Linux", "linux", ""},
+}
+
+var browserRules = []struct {
+	marker, browser string
+}{
+	{"Edg/", "edge"},
+	{"Edge/", "edge"},
+	{"OPR/", "opera"},
+	{"Opera", "opera"},
+	{"SamsungBrowser", "samsung"},
+	{"UCBrowser", "uc"},
+	{"FxiOS", "firefox"},
+	{"Firefox/", "firefox"},
+	{"CriOS", "chrome"},
+	{"Chrome/", "chrome"},
+	{"Version/", "safari"},
+	{"Trident/", "ie"},
+	{"MSIE ", "ie"},
+}
+
+var windowsVersions = map[string]string{"10.0": "10", "6.3": "8.1", "6.2": "8", "6.1": "7", "6.0": "vista", "5.1": "xp"}
+
+func ParseUserAgent(ua string) UserAgent {
+	var p UserAgent
+	for _, rule := range osRules {
+		if i := strings.Index(ua, rule.marker); i >= 0 {
+			p.OS = rule.os
+			if rule.version != "" {
+				if j := strings.Index(ua[i:], rule.version); j >= 0 {
+					p.OSVersion = leadingVersion(ua[i+j+len(rule.version):])
+				}
+			}
+			break
+		}
+	}
+	if p.OS == "windows" {
+		p.OSVersion = windowsVersions[p.OSVersion]
+	}
+
+	for _, rule := range browserRules {
+		if strings.Contains(ua, rule.marker) {
+			p.Browser = rule.browser
+			break
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") || (p.OS == "android" && !strings.Contains(ua, "Mobile")):
+		p.DeviceType = "tablet"
+	case strings.Contains(ua, "Mobi") || p.OS == "ios" || p.OS == "windows phone":
+		p.DeviceType = "mobile"
+	case p.OS == "windows" || p.OS == "macos" || p.OS == "linux" || p.OS == "chromeos":
+		p.DeviceType = "desktop"
+	}
+	return p
+}
+
+// leadingVersion reads a version like 10_15_7 or 8.1 off the front of s, as 10.15.7 or 8.1
+func leadingVersion(s string) string {
+	end := 0
+	for end < len(s) && (s[end] == '.' || s[end] == '_' || (s[end] >= '0' && s[end] <= '9')) {
+		end++
+	}
+	return strings.Trim(strings.Replace(s[:end], "_", ".", -1), ".")
+}
+
+// UserAgents fills the os and browser from the user agent, and replaces the device type when
+// it's missing, not one we know, or disagrees with a user agent that clearly says otherwise.
+type UserAgents struct{}
+
+var knownDeviceTypes = map[string]bool{"desktop": true, "mobile": true, "tablet": true}
+
+func (UserAgents) Enrich(r *rtb_types.Request) bool {
+	if r.Device.UserAgent == "" {
+		return false
+	}
+	p := ParseUserAgent(r.Device.UserAgent)
+	d := &r.Device
+	changed := false
+	if p.DeviceType != "" && p.DeviceType != strings.ToLower(d.DeviceType) && (!knownDeviceTypes[strings.ToLower(d.DeviceType)] || p.OS != "") {
+		d.DeviceType, changed = p.DeviceType, true
+	}
+	if d.OS == "" && p.OS != "" {
+		d.OS, d.OSVersion, changed = p.OS, p.OSVersion, true
+	}
+	if d.Browser == "" && p.Browser != "" {
+		d.Browser, changed = p.Browser, true
+	}
+	return changed
+}
@@ -0,0 +1,35 @@
+package gateway
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	cases := map[string]UserAgent{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 14_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0.1 Mobile/15E148 Safari/604.1": {"mobile", "ios", "14.2", "safari"},
+		"Mozilla/5.0 (Linux; Android 10; SM-T510) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.198 Safari/537.36":                        {"tablet", "android", "10", "chrome"},
+		"Mozilla/5.0 (Linux; Android 11; Pixel 5) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.91 Mobile Safari/537.36":                  {"mobile", "android", "11", "chrome"},
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36 Edg/91.0.864.59":       {"desktop", "windows", "10", "edge"},
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.1 Safari/605.1.15":                   {"desktop", "macos", "10.15.7", "safari"},
+		"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:89.0) Gecko/20100101 Firefox/89.0":                                                              {"desktop", "linux", "", "firefox"},
+		"curl/7.64.1": {},
+	}
+	for ua, want := range cases {
+		if got := ParseUserAgent(ua); got != want {
+			t.Errorf(`%s: got %+v want %+v`, ua, got, want)
+		}
+	}
+}
+
+func TestUserAgentsEnrich(t *testing.T) {
+	r := &rtb_types.Request{}
+	r.Device.DeviceType = "desktop"
+	r.Device.UserAgent = "Mozilla/5.0 (iPhone; CPU iPhone OS 14_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0.1 Mobile/15E148 Safari/604.1"
+	if !(UserAgents{}).Enrich(r) {
+		t.Error("expected a change")
+	}
+	if r.Device.DeviceType != "mobile" || r.Device.OS != "ios" || r.Device.Browser != "safari" {
+		t.Errorf(`unexpected device %+v`, r.Device)
+	}
+}
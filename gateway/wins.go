@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/clixxa/dsp/pricing"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"gopkg.in/redis.v5"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Wins taps win notices on their way to the win handler (Next). The Finisher holds each bid it
+// makes until its notice (key being the bid's id, price the clearing price in wire units) arrives
+// or Window (an hour by default, as long as ssps have to notify) passes. Bids are held in KVS so
+// any instance can take the notice, or in memory without it. A won bid is an impression to Online,
+// a win to Shader, and is handed to OnWin. Notices for bids that aren't held are passed on,
+// counted as win_unheld.
+type Wins struct {
+	Next     http.Handler
+	Online   *pricing.Online
+	Shader   *pricing.Shader
+	OnWin    []func(saleID int, b *HeldBid, price float64)
+	Window   time.Duration
+	KVS      *redis.Client
+	Counters *services.Counters
+	Messages chan string
+
+	lock sync.Mutex
+	held map[int]*HeldBid
+}
+
+// HeldBid is what's kept of a bid for its win notice: who it was for, what it was on, and the
+// hashed features (see pricing.Features) the online model learns from rather than the request.
+type HeldBid struct {
+	SSPID      int                  `json:"s"`
+	FolderID   int                  `json:"f"`
+	CreativeID int                  `json:"c"`
+	DealID     string               `json:"d,omitempty"`
+	Placement  string               `json:"p,omitempty"`
+	Test       bool                 `json:"t,omitempty"`
+	Dimensions rtb_types.Dimensions `json:"m"`
+	Features   []uint32             `json:"x"`
+	At         int64                `json:"a"`
+}
+
+func (ws *Wins) window() time.Duration {
+	if ws.Window == 0 {
+		return time.Hour
+	}
+	return ws.Window
+}
+
+func heldKey(saleID int) string {
+	return "win:" + strconv.Itoa(saleID)
+}
+
+// Hold keeps a bid until its win notice arrives, giving up on KVS once ctx is done.
+func (ws *Wins) Hold(ctx context.Context, saleID int, b *HeldBid) {
+	b.At = time.Now().Unix()
+	if ws.KVS != nil {
+		js, err := json.Marshal(b)
+		if err == nil {
+			err = services.WithContext(ctx, func() error {
+				return ws.KVS.Set(heldKey(saleID), js, ws.window()).Err()
+			})
+		}
+		if err != nil {
+			ws.Counters.Inc(b.SSPID, "win_hold_failed")
+		}
+		return
+	}
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	if ws.held == nil {
+		ws.held = make(map[int]*HeldBid)
+	}
+	ws.held[saleID] = b
+}
+
+// won takes a held bid, nil if it isn't held or another notice took it first.
+func (ws *Wins) won(saleID int) *HeldBid {
+	if ws.KVS != nil {
+		js, err := ws.KVS.Get(heldKey(saleID)).Bytes()
+		if err != nil {
+			return nil
+		}
+		if n, err := ws.KVS.Del(heldKey(saleID)).Result(); err != nil || n == 0 {
+			return nil
+		}
+		b := &HeldBid{}
+		if json.Unmarshal(js, b) != nil {
+			return nil
+		}
+		return b
+	}
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	b, ok := ws.held[saleID]
+	if !ok {
+		return nil
+	}
+	delete(ws.held, saleID)
+	return b
+}
+
+func (ws *Wins) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	saleID, _ := strconv.Atoi(q.Get("key"))
+	price, _ := strconv.ParseFloat(q.Get("price"), 64)
+	if b := ws.won(saleID); b == nil {
+		ws.Counters.Inc(SSPID(r), "win_unheld")
+	} else {
+		if ws.Online != nil {
+			ws.Online.ObserveFeatures(saleID, b.Features)
+		}
+		if ws.Shader != nil {
+			ws.Shader.Won(saleID)
+		}
+		for _, f := range ws.OnWin {
+			f(saleID, b, price)
+		}
+	}
+	ws.Next.ServeHTTP(w, r)
+}
+
+// Expire forgets bids held in memory more than Window, KVS expires its own.
+func (ws *Wins) Expire(now time.Time) int {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	n := 0
+	for id, b := range ws.held {
+		if now.Sub(time.Unix(b.At, 0)) > ws.window() {
+			delete(ws.held, id)
+			n++
+		}
+	}
+	return n
+}
+
+func (ws *Wins) Launch(errs chan error) error {
+	ws.Messages <- "launching win tap"
+	go func() {
+		for now := range time.NewTicker(time.Minute).C {
+			ws.Expire(now)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"context"
+	"github.com/clixxa/dsp/pricing"
+	"github.com/clixxa/dsp/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWins(t *testing.T) {
+	passed := 0
+	var wonAt float64
+	var won *HeldBid
+	ws := &Wins{
+		Next:     http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { passed++ }),
+		Online:   &pricing.Online{},
+		Shader:   &pricing.Shader{},
+		Counters: &services.Counters{},
+	}
+	ws.OnWin = append(ws.OnWin, func(saleID int, b *HeldBid, price float64) { won, wonAt = b, price })
+
+	// the finisher holds the bid and tells the shader of it
+	f, _ := testFinisher(bidOn(5, 11, 1))
+	f.Wins, f.Shader = ws, ws.Shader
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/7", strings.NewReader(`{"imp":[{}],"site":{"placement":"http://news.com/a"}}`)))
+
+	ws.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/win?ssp=7&key=5&price=4500", nil))
+	if passed != 1 || wonAt != 4500 {
+		t.Errorf(`win passed on %d times, at %f`, passed, wonAt)
+	}
+	if won == nil || won.SSPID != 7 || won.FolderID != 11 || won.CreativeID != 1 || won.Placement != "http://news.com/a" || len(won.Features) == 0 {
+		t.Errorf(`won %+v`, won)
+	}
+	if ws.Shader.Won(5) {
+		t.Error("the shader wasn't told of the win")
+	}
+	if !ws.Online.Convert(5) {
+		t.Error("the online model wasn't shown the impression")
+	}
+
+	// a second notice for the bid, or one for a bid never held, is only passed on
+	ws.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/win?ssp=7&key=5&price=4500", nil))
+	if passed != 2 || ws.Counters.Get(7, "win_unheld") != 1 {
+		t.Errorf(`repeat win passed on %d times, counted %d`, passed, ws.Counters.Get(7, "win_unheld"))
+	}
+
+	ws.Hold(context.Background(), 6, &HeldBid{})
+	if ws.Expire(time.Now().Add(2*time.Hour)) != 1 {
+		t.Error("held bid didn't expire")
+	}
+}
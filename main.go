@@ -4,10 +4,15 @@ import (
 	"fmt"
 	"github.com/clixxa/dsp/bindings"
 	"github.com/clixxa/dsp/dsp_flights"
+	"github.com/clixxa/dsp/gateway"
+	"github.com/clixxa/dsp/pricing"
 	"github.com/clixxa/dsp/services"
+	"github.com/clixxa/dsp/tracking"
 	"github.com/clixxa/dsp/wish_flights"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 )
 
 type Main struct {
@@ -28,23 +33,128 @@ func (m *Main) Launch() {
 	router := &services.RouterService{Messages: messages}
 	router.Mux = http.NewServeMux()
 
+	counters := &services.Counters{}
+	geo := &gateway.GeoIP{Messages: messages}
+	ivt := &gateway.IVT{}
+	ssps := &gateway.SSPs{AllowUnlisted: os.Getenv("TALLOWUNLISTEDSSPS") == "true"}
+	brandSafety := &gateway.BrandSafety{}
+	fx := &bindings.FXRates{}
+	historical := &pricing.HistoricalCTR{}
+	fallbackCTR, _ := strconv.ParseFloat(os.Getenv("TFALLBACKCTR"), 64)
+	if fallbackCTR <= 0 {
+		fallbackCTR = 0.001
+	}
+	// the online models learn from our own wins, clicks and conversions (the ctr model from wins and
+	// clicks, the cvr model from clicks and conversions), and only predict once they've seen
+	// TONLINEMINUPDATES examples (10000 by default)
+	onlineMinUpdates, _ := strconv.ParseInt(os.Getenv("TONLINEMINUPDATES"), 10, 64)
+	if onlineMinUpdates <= 0 {
+		onlineMinUpdates = 10000
+	}
+	online := &pricing.Online{Path: os.Getenv("TONLINECHECKPOINT"), MinUpdates: onlineMinUpdates, Messages: messages}
+	cvr := &pricing.Online{Path: os.Getenv("TONLINECVRCHECKPOINT"), MinUpdates: onlineMinUpdates, Messages: messages}
+	pricer := &pricing.CPMPricer{Models: []pricing.CTRModel{online, historical, pricing.GlobalCTR{HistoricalCTR: historical}}, FallbackCTR: fallbackCTR}
+	// the shader learns a bid as lost once wins stops waiting for its notice, so they share a window
+	winWindow := time.Hour
+	shader := &pricing.Shader{Window: winWindow, Messages: messages}
+	wins := &gateway.Wins{Online: online, Shader: shader, Window: winWindow, Counters: counters, Messages: messages}
+	rotation := &bindings.Rotation{}
+	wins.OnWin = append(wins.OnWin, func(saleID int, b *gateway.HeldBid, price float64) { rotation.Shown(b.FolderID, b.CreativeID) })
+	config := &gateway.Config{}
+	finisher := &gateway.Finisher{Next: dspRuntime, Config: config, SSPs: ssps, FX: fx, Pricer: pricer, Shader: shader, Wins: wins, Rotation: rotation, Counters: counters, Messages: messages}
+	bidGate := &gateway.BidGate{Next: finisher, Filters: []gateway.Filter{ivt, brandSafety}, Enrichers: []gateway.Enricher{geo, gateway.UserAgents{}}, Counters: counters, Messages: messages}
+
 	ef := &services.ErrorFilter{Tolerances: services.ConnectionErrors | services.ParsingErrors, Messages: messages}
-	router.Mux.Handle("/", dspRuntime)
+	trustedProxies, _ := strconv.Atoi(os.Getenv("TTRUSTEDPROXIES"))
+	if trustedProxies == 0 && os.Getenv("TTRUSTPROXY") == "true" {
+		trustedProxies = 1
+	}
+	bidAuth := &gateway.Auth{Next: bidGate, SSPs: ssps, Counters: counters}
+	maxInFlight, _ := strconv.ParseInt(os.Getenv("TMAXINFLIGHT"), 10, 64)
+	defaultTMax, _ := strconv.Atoi(os.Getenv("TDEFAULTTMAX"))
+	deadline := &gateway.Deadline{Next: bidAuth, SSPs: ssps, DefaultTMax: time.Duration(defaultTMax) * time.Millisecond, Counters: counters}
+	throttle := &gateway.Throttle{Next: deadline, SSPs: ssps, MaxInFlight: maxInFlight, Counters: counters}
+	router.Mux.Handle("/", &gateway.Allowlist{Next: throttle, SSPs: ssps, Counters: counters, TrustedProxies: trustedProxies})
 
 	winChan := &services.HttpToChan{Messages: messages, ObjectFactory: winRuntime.NewFlight}
-	router.Mux.Handle("/win", winChan)
+	wins.Next = winChan
+	winAuth := &gateway.Auth{Next: wins, SSPs: ssps, Counters: counters}
+	router.Mux.Handle("/win", &gateway.Allowlist{Next: winAuth, SSPs: ssps, Counters: counters, TrustedProxies: trustedProxies})
+
+	// counters tell anyone who can read them how we bid, so they're only served on the internal
+	// listener (TINTERNALADDR, loopback by default)
+	internal := &services.RouterService{Messages: messages, Mux: http.NewServeMux(), Addr: os.Getenv("TINTERNALADDR")}
+	if internal.Addr == "" {
+		internal.Addr = "127.0.0.1:8081"
+	}
+	internal.Mux.Handle("/counters", counters)
+
+	creatives := &tracking.Creatives{}
+	clickTTL, _ := strconv.Atoi(os.Getenv("TCLICKTTLHOURS"))
+	clickKey := os.Getenv("TCLICKKEY")
+	if clickKey == "" {
+		clickKey = os.Getenv("TDEFAULTKEY")
+	}
+	// tokens sealed without a key could be forged by anyone, so click tracking needs one
+	if os.Getenv("TCLICKURL") != "" && clickKey == "" {
+		fmt.Println("TCLICKURL is set without TCLICKKEY or TDEFAULTKEY, not starting")
+		return
+	}
+	redirects := &tracking.Redirects{Base: os.Getenv("TCLICKURL"), Sealer: &bindings.Sealer{Key: []byte(clickKey)}, TTL: time.Duration(clickTTL) * time.Hour, Creatives: creatives, Counters: counters, Messages: messages}
+	router.Mux.Handle("/click", redirects)
+	if redirects.Base != "" {
+		finisher.Clicks = redirects
+	}
+	conversionWindow, _ := strconv.Atoi(os.Getenv("TCONVERSIONWINDOWHOURS"))
+	conversions := &tracking.ConversionPostback{Key: os.Getenv("TCONVERSIONKEY"), Counters: counters, Messages: messages}
+	router.Mux.Handle("/conversion", conversions)
+
+	postbacks := &services.Postbacks{Messages: messages}
+	publisher := &tracking.Publisher{SSPs: ssps, Postbacks: postbacks, Counters: counters, Messages: messages}
+	wins.OnWin = append(wins.OnWin, func(saleID int, b *gateway.HeldBid, price float64) {
+		publisher.Win(saleID, b.SSPID, b.FolderID, b.CreativeID, price)
+	})
+	purchases := &gateway.Purchases{Revenue: bindings.Revenue{FX: fx, Counters: counters}, SSPs: ssps, Counters: counters, Messages: messages}
+	wins.OnWin = append(wins.OnWin, purchases.Win)
+	redirects.OnClick = append(redirects.OnClick, publisher.Click)
+	redirects.OnClick = append(redirects.OnClick, func(clickID string, t *bindings.ClickToken) {
+		if features, ok := online.Pending(t.SaleID); ok {
+			cvr.ObserveFeatures(t.SaleID, features)
+		}
+		online.Convert(t.SaleID)
+		rotation.Succeeded(t.FolderID, t.CreativeID)
+	})
+	conversions.OnConversion = append(conversions.OnConversion, publisher.Conversion)
+	conversions.OnConversion = append(conversions.OnConversion, func(c *bindings.Conversion) { cvr.Convert(c.SaleID) })
+	conversions.OnConversion = append(conversions.OnConversion, rotation.Converted)
 
 	launch := &services.LaunchService{Messages: messages}
 
 	wireUp := &services.CycleService{Proxy: func(func(error) bool) {
 		dspRuntime.BindingDeps = deps.BindingDeps
 		winRuntime.BindingDeps = deps.BindingDeps
+		ivt.KVS = deps.BindingDeps.KVS
+		ssps.BindingDeps = deps.BindingDeps
+		brandSafety.BindingDeps = deps.BindingDeps
+		config.BindingDeps = deps.BindingDeps
+		bidAuth.KVS = deps.BindingDeps.KVS
+		winAuth.KVS = deps.BindingDeps.KVS
+		creatives.BindingDeps = deps.BindingDeps
+		fx.BindingDeps = deps.BindingDeps
+		historical.BindingDeps = deps.BindingDeps
+		rotation.BindingDeps = deps.BindingDeps
+		wins.KVS = deps.BindingDeps.KVS
+		redirects.Clicks = bindings.Clicks{Env: deps.BindingDeps}
+		redirects.KVS = deps.BindingDeps.KVS
+		conversions.Conversions = bindings.Conversions{Env: deps.BindingDeps, Window: time.Duration(conversionWindow) * time.Hour}
+		purchases.Saver = bindings.Purchases{Env: deps.BindingDeps}
+		postbacks.UseDB(deps.BindingDeps.StatsDB)
 		printer.PrintTo = deps.BindingDeps.Logger
 	}}
 
 	cycler := &services.CycleService{ErrorFilter: ef.Quit, Messages: messages}
-	cycler.Children = append(cycler.Children, consul, deps, wireUp, dspRuntime, winRuntime)
-	launch.Children = append(launch.Children, cycler, printer, router, winRuntime)
+	cycler.Children = append(cycler.Children, consul, deps, wireUp, ssps, creatives, geo, ivt, brandSafety, config, fx, historical, rotation, dspRuntime, winRuntime)
+	launch.Children = append(launch.Children, cycler, printer, router, internal, winRuntime, geo, postbacks, publisher, shader, online, cvr, wins, purchases)
 
 	fmt.Println("starting launcher")
 	fmt.Println("launch returned", launch.Launch())
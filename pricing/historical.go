@@ -0,0 +1,166 @@
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/clixxa/dsp/services"
+	"strings"
+	"sync"
+)
+
+const sqlHistoricalCTR = `SELECT p.folder_id, p.creative_id, p.placement, COUNT(*), COUNT(c.sale_id) FROM purchases p LEFT JOIN clicks c ON c.sale_id = p.sale_id WHERE p.created_at > NOW() - INTERVAL '%d DAY' GROUP BY p.folder_id, p.creative_id, p.placement`
+
+type counts struct {
+	Impressions float64
+	Clicks      float64
+}
+
+type ctrKey struct {
+	FolderID   int
+	CreativeID int
+	Placement  string
+}
+
+// HistoricalCTR predicts from the last Days of purchases and clicks in the stats db. Each level
+// (folder, creative in folder, placement of creative) is smoothed towards the one above it as if
+// it had PriorWeight extra impressions at that ctr, the folders towards the average of all of
+// them. Folders without impressions get no prediction. Cycle reloads it, keeping the last levels
+// if the stats db can't be read.
+type HistoricalCTR struct {
+	BindingDeps services.BindingDeps
+	Days        int
+	PriorWeight float64
+
+	lock   sync.RWMutex
+	global float64
+	levels map[ctrKey]float64
+}
+
+func (h *HistoricalCTR) Cycle(quit func(error) bool) {
+	if h.BindingDeps.StatsDB == nil {
+		return
+	}
+	err := h.Unmarshal(0, h.BindingDeps)
+	quit(services.ErrDatabaseMissing{Name: "historical ctr", UnderlyingErr: err})
+}
+
+func (h *HistoricalCTR) Unmarshal(depth int, env services.BindingDeps) error {
+	if h.Days == 0 {
+		h.Days = 7
+	}
+	if h.PriorWeight == 0 {
+		h.PriorWeight = 100
+	}
+
+	rows, err := env.StatsDB.Query(fmt.Sprintf(sqlHistoricalCTR, h.Days))
+	if err != nil {
+		env.Debug.Println("err", err)
+		return err
+	}
+	var found []ctrRow
+	for rows.Next() {
+		var row ctrRow
+		if err := rows.Scan(&row.FolderID, &row.CreativeID, &row.Placement, &row.Impressions, &row.Clicks); err != nil {
+			env.Debug.Println("err", err)
+			return err
+		}
+		found = append(found, row)
+	}
+	global, levels := h.fit(found)
+
+	h.lock.Lock()
+	h.global, h.levels = global, levels
+	h.lock.Unlock()
+
+	env.Debug.Printf("LOADED %s %T %d levels, global ctr %f", strings.Repeat("\t", depth), h, len(levels), global)
+	return nil
+}
+
+type ctrRow struct {
+	ctrKey
+	counts
+}
+
+func (h *HistoricalCTR) fit(rows []ctrRow) (float64, map[ctrKey]float64) {
+	var total counts
+	folders := map[ctrKey]*counts{}
+	creatives := map[ctrKey]*counts{}
+	placements := map[ctrKey]*counts{}
+	add := func(m map[ctrKey]*counts, k ctrKey, c counts) {
+		if m[k] == nil {
+			m[k] = &counts{}
+		}
+		m[k].Impressions += c.Impressions
+		m[k].Clicks += c.Clicks
+	}
+	for _, row := range rows {
+		k := row.ctrKey
+		k.Placement = strings.ToLower(k.Placement)
+		total.Impressions += row.Impressions
+		total.Clicks += row.Clicks
+		add(folders, ctrKey{FolderID: k.FolderID}, row.counts)
+		add(creatives, ctrKey{FolderID: k.FolderID, CreativeID: k.CreativeID}, row.counts)
+		// without a placement the key would be the creative's own
+		if k.Placement != "" {
+			add(placements, k, row.counts)
+		}
+	}
+
+	var global float64
+	if total.Impressions > 0 {
+		global = total.Clicks / total.Impressions
+	}
+	levels := map[ctrKey]float64{}
+	smooth := func(c *counts, prior float64) float64 {
+		return (c.Clicks + h.PriorWeight*prior) / (c.Impressions + h.PriorWeight)
+	}
+	for k, c := range folders {
+		levels[k] = smooth(c, global)
+	}
+	for k, c := range creatives {
+		levels[k] = smooth(c, levels[ctrKey{FolderID: k.FolderID}])
+	}
+	for k, c := range placements {
+		levels[k] = smooth(c, levels[ctrKey{FolderID: k.FolderID, CreativeID: k.CreativeID}])
+	}
+	return global, levels
+}
+
+func (h *HistoricalCTR) PredictCTR(c *Context) (float64, bool) {
+	keys := []ctrKey{{FolderID: c.FolderID, CreativeID: c.CreativeID}, {FolderID: c.FolderID}}
+	if c.Placement != "" {
+		keys = append([]ctrKey{{c.FolderID, c.CreativeID, strings.ToLower(c.Placement)}}, keys...)
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for _, k := range keys {
+		if ctr, ok := h.levels[k]; ok {
+			return ctr, true
+		}
+	}
+	return 0, false
+}
+
+// Global is the average ctr over every folder, a reasonable fallback for new ones.
+func (h *HistoricalCTR) Global() float64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.global
+}
+
+// GlobalCTR predicts the historical average for everything, for cold-start folders. Put it after
+// the HistoricalCTR in CPMPricer.Models.
+type GlobalCTR struct {
+	*HistoricalCTR
+}
+
+func (g GlobalCTR) PredictCTR(c *Context) (float64, bool) {
+	ctr := g.Global()
+	return ctr, ctr > 0
+}
+
+func (h *HistoricalCTR) MarshalJSON() ([]byte, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return json.Marshal(map[string]interface{}{"global": h.global, "levels": len(h.levels)})
+}
@@ -0,0 +1,347 @@
+package pricing
+
+import (
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Features hashes a context into 2^bits feature indexes: a bias, the folder, creative and ssp, each
+// dimension id, the keywords, the placement's host and full path, and a few crosses of them.
+func Features(c *Context, bits uint) []uint32 {
+	var names []string
+	add := func(format string, args ...interface{}) {
+		names = append(names, fmt.Sprintf(format, args...))
+	}
+	host := placementHost(c.Placement)
+
+	add("bias")
+	add("folder=%d", c.FolderID)
+	add("creative=%d", c.CreativeID)
+	add("ssp=%d", c.SSPID)
+	add("host=%s", host)
+	add("placement=%s", strings.ToLower(c.Placement))
+	add("folder=%d,host=%s", c.FolderID, host)
+	if d := c.Dimensions; d != nil {
+		add("vertical=%d", d.VerticalID)
+		add("brand=%d", d.BrandID)
+		add("network=%d", d.NetworkID)
+		add("subnetwork=%d", d.SubNetworkID)
+		add("networktype=%d", d.NetworkTypeID)
+		add("devicetype=%d", d.DeviceTypeID)
+		add("country=%d", d.CountryID)
+		add("gender=%d", d.GenderID)
+		add("interest=%d", d.InterestID)
+		add("angle=%d", d.AngleID)
+		add("subchannel=%d", d.SubchannelID)
+		add("os=%d", d.OSID)
+		add("browser=%d", d.BrowserID)
+		add("creative=%d,country=%d", c.CreativeID, d.CountryID)
+		add("creative=%d,devicetype=%d", c.CreativeID, d.DeviceTypeID)
+	}
+	if c.Request != nil {
+		for _, kw := range c.Request.Site.Keywords {
+			add("keyword=%s", strings.ToLower(kw))
+		}
+	}
+
+	mask := uint32(1)<<bits - 1
+	idx := make([]uint32, len(names))
+	for i, n := range names {
+		h := fnv.New32a()
+		h.Write([]byte(n))
+		idx[i] = h.Sum32() & mask
+	}
+	return idx
+}
+
+// placementHost is the lowercased host of a placement url, or the whole placement if it isn't one.
+func placementHost(placement string) string {
+	if u, err := url.Parse(placement); err == nil && u.Host != "" {
+		return strings.ToLower(u.Host)
+	}
+	return strings.ToLower(placement)
+}
+
+// Online is a logistic regression over hashed Features, learnt incrementally with FTRL-proximal.
+// As a ctr model it's shown wins (Observe) and clicks (Convert); as a cvr model, clicks and
+// conversions. Anything observed but not converted within Window is learnt as a negative. The
+// weights are checkpointed to Path, and it doesn't predict until it has seen MinUpdates examples.
+type Online struct {
+	Bits       uint
+	Alpha      float64
+	Beta       float64
+	L1         float64
+	L2         float64
+	Window     time.Duration
+	Path       string
+	MinUpdates int64
+	Messages   chan string
+
+	lock    sync.Mutex
+	z       []float64
+	n       []float64
+	updates int64
+	pending map[int]*pendingExample
+}
+
+type pendingExample struct {
+	features []uint32
+	at       time.Time
+}
+
+func (m *Online) init() {
+	if m.z != nil {
+		return
+	}
+	if m.Bits == 0 {
+		m.Bits = 20
+	}
+	if m.Alpha == 0 {
+		m.Alpha = 0.05
+	}
+	if m.Beta == 0 {
+		m.Beta = 1
+	}
+	if m.Window == 0 {
+		m.Window = time.Hour
+	}
+	m.z = make([]float64, 1<<m.Bits)
+	m.n = make([]float64, 1<<m.Bits)
+	m.pending = make(map[int]*pendingExample)
+}
+
+func (m *Online) weight(i uint32) float64 {
+	z := m.z[i]
+	if math.Abs(z) <= m.L1 {
+		return 0
+	}
+	sign := 1.0
+	if z < 0 {
+		sign = -1
+	}
+	return -(z - sign*m.L1) / ((m.Beta+math.Sqrt(m.n[i]))/m.Alpha + m.L2)
+}
+
+func (m *Online) predict(features []uint32) float64 {
+	var wx float64
+	for _, i := range features {
+		wx += m.weight(i)
+	}
+	wx = math.Max(math.Min(wx, 35), -35)
+	return 1 / (1 + math.Exp(-wx))
+}
+
+func (m *Online) Predict(features []uint32) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.init()
+	return m.predict(features)
+}
+
+func (m *Online) PredictCTR(c *Context) (float64, bool) {
+	// hashing is most of the work, so it's done before locking and cut down to Bits after, which
+	// a Load could change meanwhile
+	features := Features(c, 32)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.init()
+	if m.updates < m.MinUpdates {
+		return 0, false
+	}
+	return m.predict(m.mask(features)), true
+}
+
+// mask cuts full width feature hashes down to indexes into the current weights.
+func (m *Online) mask(features []uint32) []uint32 {
+	mask := uint32(len(m.z) - 1)
+	for i := range features {
+		features[i] &= mask
+	}
+	return features
+}
+
+// Update learns a single example.
+func (m *Online) Update(features []uint32, positive bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.init()
+	m.update(features, positive)
+}
+
+func (m *Online) update(features []uint32, positive bool) {
+	y := 0.0
+	if positive {
+		y = 1
+	}
+	g := m.predict(features) - y
+	for _, i := range features {
+		sigma := (math.Sqrt(m.n[i]+g*g) - math.Sqrt(m.n[i])) / m.Alpha
+		m.z[i] += g - sigma*m.weight(i)
+		m.n[i] += g * g
+	}
+	m.updates++
+}
+
+// Observe holds an impression (or click) until it converts or the Window passes.
+func (m *Online) Observe(id int, c *Context) {
+	m.ObserveFeatures(id, Features(c, 32))
+}
+
+// ObserveFeatures is Observe for features hashed earlier, with Features(c, 32) or taken from
+// Pending, so the context needn't be kept.
+func (m *Online) ObserveFeatures(id int, features []uint32) {
+	features = append([]uint32(nil), features...)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.init()
+	m.pending[id] = &pendingExample{m.mask(features), time.Now()}
+}
+
+// Pending returns the features of an observed id that hasn't converted or expired yet.
+func (m *Online) Pending(id int) ([]uint32, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.init()
+	p, ok := m.pending[id]
+	if !ok {
+		return nil, false
+	}
+	return append([]uint32(nil), p.features...), true
+}
+
+// Convert learns an observed id as a positive. It returns false if the id isn't pending.
+func (m *Online) Convert(id int) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.init()
+	p, ok := m.pending[id]
+	if !ok {
+		return false
+	}
+	delete(m.pending, id)
+	m.update(p.features, true)
+	return true
+}
+
+// Expire learns everything observed more than Window ago as a negative.
+func (m *Online) Expire(now time.Time) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.init()
+	n := 0
+	for id, p := range m.pending {
+		if now.Sub(p.at) > m.Window {
+			delete(m.pending, id)
+			m.update(p.features, false)
+			n++
+		}
+	}
+	return n
+}
+
+type checkpoint struct {
+	Bits    uint
+	Z       []float64
+	N       []float64
+	Updates int64
+}
+
+// Save checkpoints the weights to Path, via a temporary file so a crash can't leave half of one.
+func (m *Online) Save() error {
+	m.lock.Lock()
+	m.init()
+	cp := checkpoint{m.Bits, append([]float64(nil), m.z...), append([]float64(nil), m.n...), m.updates}
+	m.lock.Unlock()
+
+	f, err := os.Create(m.Path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(&cp); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(m.Path+".tmp", m.Path)
+}
+
+// Load restores the weights from Path. A missing checkpoint isn't an error, the model starts empty.
+func (m *Online) Load() error {
+	f, err := os.Open(m.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	var cp checkpoint
+	if err := gob.NewDecoder(f).Decode(&cp); err != nil {
+		return err
+	}
+	if len(cp.Z) != 1<<cp.Bits || len(cp.N) != len(cp.Z) {
+		return fmt.Errorf(`checkpoint %s is corrupt`, m.Path)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Bits = cp.Bits
+	m.z = nil
+	m.init()
+	m.z, m.n, m.updates = cp.Z, cp.N, cp.Updates
+	return nil
+}
+
+// Launch loads the checkpoint, then every minute learns expired observations and checkpoints.
+func (m *Online) Launch(errs chan error) error {
+	m.Messages <- "launching online model " + m.Path
+	if m.Path != "" {
+		if err := m.Load(); err != nil {
+			return err
+		}
+	}
+	go func() {
+		for now := range time.NewTicker(time.Minute).C {
+			m.Expire(now)
+			if m.Path == "" {
+				continue
+			}
+			if err := m.Save(); err != nil {
+				errs <- err
+			}
+		}
+	}()
+	return nil
+}
+
+// Example is a line of an evaluation log.
+type Example struct {
+	Context   Context
+	Converted bool
+}
+
+// LogLoss is the model's mean log loss over the examples.
+func (m *Online) LogLoss(examples []Example) float64 {
+	if len(examples) == 0 {
+		return 0
+	}
+	var loss float64
+	for i := range examples {
+		p := m.Predict(Features(&examples[i].Context, m.Bits))
+		p = math.Max(math.Min(p, 1-1e-15), 1e-15)
+		if examples[i].Converted {
+			loss -= math.Log(p)
+		} else {
+			loss -= math.Log(1 - p)
+		}
+	}
+	return loss / float64(len(examples))
+}
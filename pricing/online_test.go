@@ -0,0 +1,68 @@
+package pricing
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOnlineLearns(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	ctrs := map[int]float64{1: 0.2, 2: 0.01}
+	examples := func(n int) []Example {
+		var ex []Example
+		for i := 0; i < n; i++ {
+			folder := 1 + i%2
+			ex = append(ex, Example{Context{FolderID: folder}, rnd.Float64() < ctrs[folder]})
+		}
+		return ex
+	}
+
+	m := &Online{Bits: 16}
+	for _, e := range examples(20000) {
+		m.Update(Features(&e.Context, m.Bits), e.Converted)
+	}
+	high, _ := m.PredictCTR(&Context{FolderID: 1})
+	low, _ := m.PredictCTR(&Context{FolderID: 2})
+	t.Log("predicted", high, low)
+	if high < 0.15 || high > 0.25 || low > 0.03 {
+		t.Errorf(`predictions %f and %f are off`, high, low)
+	}
+
+	held := examples(2000)
+	loss := m.LogLoss(held)
+	baseline := (&Online{Bits: 16}).LogLoss(held)
+	t.Log("log loss", loss, "untrained", baseline)
+	if loss >= baseline {
+		t.Error("training didn't improve log loss")
+	}
+
+	m.Path = filepath.Join(os.TempDir(), "online_test.ckpt")
+	defer os.Remove(m.Path)
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	m2 := &Online{Path: m.Path}
+	if err := m2.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if loss2 := m2.LogLoss(held); loss2 != loss {
+		t.Errorf(`restored model has log loss %f, not %f`, loss2, loss)
+	}
+}
+
+func TestOnlineObserveConvert(t *testing.T) {
+	m := &Online{Bits: 10}
+	m.Observe(1, &Context{FolderID: 1})
+	m.Observe(2, &Context{FolderID: 1})
+	if !m.Convert(1) || m.Convert(1) || m.Convert(3) {
+		t.Error("convert should only succeed once for observed ids")
+	}
+	if m.Expire(m.pending[2].at) != 0 {
+		t.Error("expired before the window")
+	}
+	if m.Expire(m.pending[2].at.Add(2*m.Window)) != 1 || m.updates != 2 {
+		t.Errorf(`expected 2 updates, have %d`, m.updates)
+	}
+}
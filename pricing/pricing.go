@@ -0,0 +1,40 @@
+package pricing
+
+import (
+	"github.com/clixxa/dsp/rtb_types"
+)
+
+// Context is what a price is being worked out for.
+type Context struct {
+	SSPID      int
+	FolderID   int
+	CreativeID int
+	Placement  string
+	Request    *rtb_types.Request
+	Dimensions *rtb_types.Dimensions
+}
+
+// CTRModel predicts the chance a won impression gets clicked. ok is false when the model has
+// nothing to go on for the context.
+type CTRModel interface {
+	PredictCTR(c *Context) (ctr float64, ok bool)
+}
+
+// CPMPricer converts a folder's cpc into the cpm price an ssp needs, cpc x ctr x 1000, asking each
+// of Models in turn for the ctr and using FallbackCTR when none of them know.
+type CPMPricer struct {
+	Models      []CTRModel
+	FallbackCTR float64
+}
+
+// CPM takes and returns prices in wire units, see rtb_types.PriceScale.
+func (p *CPMPricer) CPM(cpc float64, c *Context) (cpm float64, ctr float64) {
+	ctr = p.FallbackCTR
+	for _, m := range p.Models {
+		if predicted, ok := m.PredictCTR(c); ok {
+			ctr = predicted
+			break
+		}
+	}
+	return cpc * ctr * 1000, ctr
+}
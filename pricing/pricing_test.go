@@ -0,0 +1,135 @@
+package pricing
+
+import (
+	"github.com/clixxa/dsp/bindings"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type fixedCTR struct {
+	ctr float64
+	ok  bool
+}
+
+func (f fixedCTR) PredictCTR(*Context) (float64, bool) {
+	return f.ctr, f.ok
+}
+
+func TestCPMPricer(t *testing.T) {
+	p := &CPMPricer{Models: []CTRModel{fixedCTR{0, false}, fixedCTR{0.02, true}}, FallbackCTR: 0.001}
+	if cpm, ctr := p.CPM(500, &Context{}); cpm != 10000 || ctr != 0.02 {
+		t.Errorf(`got cpm %f ctr %f`, cpm, ctr)
+	}
+	p.Models = p.Models[:1]
+	if cpm, _ := p.CPM(500, &Context{}); cpm != 500 {
+		t.Errorf(`expected the fallback ctr, got cpm %f`, cpm)
+	}
+}
+
+func TestHistoricalLevels(t *testing.T) {
+	h := &HistoricalCTR{levels: map[ctrKey]float64{
+		{FolderID: 1}:                0.01,
+		{FolderID: 1, CreativeID: 2}: 0.02,
+		{1, 2, "example.org/sports"}: 0.03,
+	}}
+	cases := map[Context]float64{
+		{FolderID: 1, CreativeID: 2, Placement: "Example.org/Sports"}: 0.03,
+		{FolderID: 1, CreativeID: 2, Placement: "example.org/news"}:   0.02,
+		{FolderID: 1, CreativeID: 3}:                                  0.01,
+	}
+	for c, want := range cases {
+		if got, ok := h.PredictCTR(&c); !ok || got != want {
+			t.Errorf(`%+v: got %f want %f`, c, got, want)
+		}
+	}
+	if _, ok := h.PredictCTR(&Context{FolderID: 2}); ok {
+		t.Error("expected no prediction for a new folder")
+	}
+}
+
+func TestHistoricalFit(t *testing.T) {
+	h := &HistoricalCTR{PriorWeight: 100}
+	global, levels := h.fit([]ctrRow{
+		{ctrKey{1, 2, ""}, counts{Impressions: 1000, Clicks: 0}},
+		{ctrKey{1, 2, "example.org"}, counts{Impressions: 1000, Clicks: 100}},
+	})
+	if global != 0.05 {
+		t.Errorf(`expected a global ctr of 0.05, got %f`, global)
+	}
+	// rows without a placement count towards the creative but don't get a level of their own
+	if len(levels) != 3 {
+		t.Errorf(`expected 3 levels, got %v`, levels)
+	}
+	h.levels = levels
+	creative, _ := h.PredictCTR(&Context{FolderID: 1, CreativeID: 2})
+	placed, _ := h.PredictCTR(&Context{FolderID: 1, CreativeID: 2, Placement: "Example.org"})
+	if creative <= 0.04 || creative >= 0.06 || placed <= creative {
+		t.Errorf(`got creative ctr %f and placement ctr %f`, creative, placed)
+	}
+}
+
+func TestShader(t *testing.T) {
+	// clearing prices are lognormal around 2 cpm
+	rnd := rand.New(rand.NewSource(1))
+	clearing := func() float64 { return 2000 * math.Exp(0.3*rnd.NormFloat64()) }
+	winRate := func(bid float64) float64 {
+		won := 0
+		for i := 0; i < 10000; i++ {
+			if clearing() < bid {
+				won++
+			}
+		}
+		return float64(won) / 10000
+	}
+
+	s := &Shader{}
+	c := &Context{SSPID: 1, Placement: "http://example.com/a"}
+	if s.Shade(nil, c, 4000, 0) != 4000 {
+		t.Error("shaded before learning anything")
+	}
+	for i := 0; i < 20000; i++ {
+		bid := 1000 + 4000*rnd.Float64()
+		s.Record(c, bid, clearing() < bid)
+	}
+
+	shaded := s.Shade(nil, c, 4000, 0)
+	full, lowered := 0.0, (4000-shaded)*winRate(shaded)
+	t.Log("shaded 4000 to", shaded, "surplus", lowered, "vs", full)
+	if shaded >= 4000 || shaded < 2000 || lowered <= full {
+		t.Errorf(`shading to %f doesn't help`, shaded)
+	}
+	if other := s.Shade(nil, &Context{SSPID: 2, Placement: "http://example.com/a"}, 4000, 0); other != 4000 {
+		t.Error("shaded an ssp without outcomes")
+	}
+	if optOut := s.Shade(&bindings.Folder{NoShading: true}, c, 4000, 0); optOut != 4000 {
+		t.Error("shaded a folder that opted out")
+	}
+	if small := s.Shade(nil, c, 100, 0); small > 100 || small < 50 {
+		t.Errorf(`shaded 100 to %f, outside the min factor and ceiling`, small)
+	}
+	if floored := s.Shade(nil, c, 4000, shaded+500); floored < shaded+500 || floored > 4000 {
+		t.Errorf(`shaded to %f, under the %f floor`, floored, shaded+500)
+	}
+	if s.Shade(nil, c, 4000, 5000) != 4000 {
+		t.Error("moved a bid that's already under the floor")
+	}
+}
+
+func TestShaderLateWin(t *testing.T) {
+	s := &Shader{}
+	c := &Context{SSPID: 1}
+	s.Bid(1, c, 2000)
+	s.Bid(2, c, 2000)
+	// ssps have an hour to send a win notice, one half an hour late is still a win
+	if n := s.Expire(time.Now().Add(30 * time.Minute)); n != 0 {
+		t.Errorf(`%d bids were learnt as lost within the hour`, n)
+	}
+	if !s.Won(1) {
+		t.Error("a win half an hour after the bid wasn't held")
+	}
+	if n := s.Expire(time.Now().Add(2 * time.Hour)); n != 1 || s.Won(2) {
+		t.Errorf(`expired %d bids after two hours`, n)
+	}
+}
@@ -0,0 +1,196 @@
+package pricing
+
+import (
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/rtb_types"
+	"math"
+	"sync"
+	"time"
+)
+
+// shadeKey is what win rates are learnt per, the ssp and the host of the placement.
+type shadeKey struct {
+	SSPID int
+	Host  string
+}
+
+// winCurve is the chance of winning at a bid, sigmoid(a + b ln(cpm)) with the cpm in currency
+// units, fitted with adagrad.
+type winCurve struct {
+	a, b   float64
+	ga, gb float64
+	n      int
+}
+
+func (w *winCurve) prob(bid float64) float64 {
+	x := math.Log(bid / rtb_types.PriceScale)
+	return 1 / (1 + math.Exp(-(w.a + w.b*x)))
+}
+
+func (w *winCurve) update(bid float64, won bool, rate float64) {
+	y := 0.0
+	if won {
+		y = 1
+	}
+	x := math.Log(bid / rtb_types.PriceScale)
+	g := w.prob(bid) - y
+	w.ga += g * g
+	w.gb += g * x * g * x
+	w.a -= rate * g / math.Sqrt(w.ga+1e-9)
+	w.b -= rate * g * x / math.Sqrt(w.gb+1e-9)
+	w.n++
+}
+
+// Shader lowers first price bids to where (value - bid) x P(win at bid) is highest, learning P per
+// ssp and placement host from wins and losses. Bids are never raised, so the folder's own price is
+// the ceiling, never lowered below MinFactor of it, and left alone for folders with NoShading or
+// until MinObservations outcomes have been seen. A bid with no win notice within Window (an hour
+// by default, as long as ssps have to send one) is a loss, so Window mustn't be shorter than the
+// win tap's (gateway.Wins).
+type Shader struct {
+	MinFactor       float64
+	MinObservations int
+	Rate            float64
+	Window          time.Duration
+	Messages        chan string
+
+	lock    sync.Mutex
+	curves  map[shadeKey]*winCurve
+	pending map[int]*pendingBid
+}
+
+type pendingBid struct {
+	key shadeKey
+	bid float64
+	at  time.Time
+}
+
+func (s *Shader) init() {
+	if s.curves != nil {
+		return
+	}
+	if s.MinFactor == 0 {
+		s.MinFactor = 0.5
+	}
+	if s.MinObservations == 0 {
+		s.MinObservations = 200
+	}
+	if s.Rate == 0 {
+		s.Rate = 0.1
+	}
+	if s.Window == 0 {
+		s.Window = time.Hour
+	}
+	s.curves = make(map[shadeKey]*winCurve)
+	s.pending = make(map[int]*pendingBid)
+}
+
+func keyFor(c *Context) shadeKey {
+	return shadeKey{c.SSPID, placementHost(c.Placement)}
+}
+
+// Shade takes the folder's unshaded price and the impression's floor and returns what to bid, all
+// in wire units. The bid is never shaded below the floor.
+func (s *Shader) Shade(f *bindings.Folder, c *Context, value, floor float64) float64 {
+	if value <= 0 || value <= floor || (f != nil && f.NoShading) {
+		return value
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init()
+	curve := s.curves[keyFor(c)]
+	if curve == nil || curve.n < s.MinObservations {
+		return value
+	}
+	best, bestSurplus := value, 0.0
+	for factor := s.MinFactor; factor < 1; factor += 0.01 {
+		bid := value * factor
+		if bid < floor {
+			continue
+		}
+		if surplus := (value - bid) * curve.prob(bid); surplus > bestSurplus {
+			best, bestSurplus = bid, surplus
+		}
+	}
+	return best
+}
+
+// WinProbability is the learnt chance of winning at a bid, ok is false if nothing's been learnt.
+func (s *Shader) WinProbability(c *Context, bid float64) (float64, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init()
+	curve := s.curves[keyFor(c)]
+	if curve == nil {
+		return 0, false
+	}
+	return curve.prob(bid), true
+}
+
+// Record learns the outcome of a bid.
+func (s *Shader) Record(c *Context, bid float64, won bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init()
+	s.record(keyFor(c), bid, won)
+}
+
+func (s *Shader) record(k shadeKey, bid float64, won bool) {
+	if bid <= 0 {
+		return
+	}
+	curve := s.curves[k]
+	if curve == nil {
+		curve = &winCurve{b: 1}
+		s.curves[k] = curve
+	}
+	curve.update(bid, won, s.Rate)
+}
+
+// Bid holds a bid until its win notice arrives or the Window passes.
+func (s *Shader) Bid(id int, c *Context, bid float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init()
+	s.pending[id] = &pendingBid{keyFor(c), bid, time.Now()}
+}
+
+// Won learns a held bid as a win. It returns false if the bid isn't held.
+func (s *Shader) Won(id int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init()
+	p, ok := s.pending[id]
+	if !ok {
+		return false
+	}
+	delete(s.pending, id)
+	s.record(p.key, p.bid, true)
+	return true
+}
+
+// Expire learns every bid held more than Window as a loss.
+func (s *Shader) Expire(now time.Time) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init()
+	n := 0
+	for id, p := range s.pending {
+		if now.Sub(p.at) > s.Window {
+			delete(s.pending, id)
+			s.record(p.key, p.bid, false)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Shader) Launch(errs chan error) error {
+	s.Messages <- "launching bid shader"
+	go func() {
+		for now := range time.NewTicker(10 * time.Second).C {
+			s.Expire(now)
+		}
+	}()
+	return nil
+}
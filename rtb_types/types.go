@@ -1,11 +1,20 @@
 package rtb_types
 
+// PriceScale is how many price units make one currency unit. Prices on the wire (bidfloor, price
+// and AUCTION_PRICE) are CPM in thousandths of their currency, so a bidfloor of 1500 in USD is
+// 1.50 USD per thousand impressions. The url method's rpm is CPM in whole currency units.
+const PriceScale = 1000
+
+// DefaultCurrency is assumed when a request or response doesn't name its currency.
+const DefaultCurrency = "USD"
+
 type Impression struct {
-	ID        string `json:"id"`
-	HourCount int    `json:"hourcount"`
-	BidFloor  int    `json:"bidfloor"`
-	PMP       PMP    `json:"pmp"`
-	Redirect  struct {
+	ID          string `json:"id"`
+	HourCount   int    `json:"hourcount"`
+	BidFloor    int    `json:"bidfloor"`
+	BidFloorCur string `json:"bidfloorcur"`
+	PMP         PMP    `json:"pmp"`
+	Redirect    struct {
 		BannedAttributes []string `json:"battr"`
 	} `json:"redirect"`
 }
@@ -15,15 +24,16 @@ type PMP struct {
 }
 
 type Deal struct {
-	ID       string `json:"id"`
-	BidFloor int    `json:"bidfloor"`
+	ID          string `json:"id"`
+	BidFloor    int    `json:"bidfloor"`
+	BidFloorCur string `json:"bidfloorcur"`
 }
 
 type Deals []*Deal
 
 func (dls *Deals) ByID(id string) *Deal {
 	for _, d := range *dls {
-		if d.ID == id {
+		if d != nil && d.ID == id {
 			return d
 		}
 	}
@@ -33,13 +43,17 @@ func (dls *Deals) ByID(id string) *Deal {
 type Request struct {
 	Random255   int          `json:"rand"`
 	Test        bool         `json:"test"`
+	TMax        int          `json:"tmax"`
 	Impressions []Impression `json:"imp"`
+	BlockedCats []string     `json:"bcat"`
+	BlockedAdvs []string     `json:"badv"`
 	Site        struct {
 		Placement   string   `json:"placement"`
 		Vertical    string   `json:"vertical"`
 		Brand       string   `json:"brand"`
 		Network     string   `json:"network"`
 		SubNetwork  string   `json:"subnetwork"`
+		Subchannel  string   `json:"subchannel"`
 		NetworkType string   `json:"networktype"`
 		Angle       string   `json:"angle"`
 		Depth       int      `json:"depth"`
@@ -48,8 +62,13 @@ type Request struct {
 	Device struct {
 		UserAgent  string `json:"ua"`
 		DeviceType string `json:"devicetype"`
+		OS         string `json:"os"`
+		OSVersion  string `json:"osv"`
+		Browser    string `json:"browser"`
 		Geo        struct {
 			Country string `json:"country"`
+			Region  string `json:"region"`
+			City    string `json:"city"`
 		} `json:"geo"`
 	} `json:"device"`
 	User struct {
@@ -61,10 +80,33 @@ type Request struct {
 	} `json:"user"`
 }
 
+// FirstImpression is the impression bid on, an empty one if the request has none.
+func (r *Request) FirstImpression() *Impression {
+	if len(r.Impressions) == 0 {
+		return &Impression{}
+	}
+	return &r.Impressions[0]
+}
+
 type Bid struct {
+	ID     int64   `json:"id,omitempty"`
 	Price  float64 `json:"price"`
 	URL    string  `json:"rurl"`
 	WinUrl string  `json:"nurl"`
+	DealID string  `json:"dealid,omitempty"`
+
+	Attributes []string `json:"attr,omitempty"`
+	Categories []string `json:"cat,omitempty"`
+	Domains    []string `json:"adomain,omitempty"`
+
+	Ext *BidExt `json:"ext,omitempty"`
+}
+
+// BidExt is what the bidder tells the gateway about a bid, the folder and creative it chose. It's
+// removed before the bid is sent to the ssp.
+type BidExt struct {
+	FolderID   int `json:"folder"`
+	CreativeID int `json:"creative"`
 }
 
 type SeatBid struct {
@@ -73,6 +115,12 @@ type SeatBid struct {
 
 type Response struct {
 	SeatBids []SeatBid `json:"seatbid"`
+	Currency string    `json:"cur,omitempty"`
+}
+
+// RPM converts a wire price to the url method's rpm.
+func RPM(price float64) float64 {
+	return price / PriceScale
 }
 
 type Dimensions struct {
@@ -86,4 +134,7 @@ type Dimensions struct {
 	GenderID      int
 	InterestID    int
 	AngleID       int
+	SubchannelID  int
+	OSID          int
+	BrowserID     int
 }
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"hash/crc32"
 	"math/rand"
@@ -31,10 +32,21 @@ func (r *RandomCache) String() string {
 }
 
 func (r *RandomCache) FindID(val string) (int, error) {
+	return r.FindIDContext(context.Background(), val)
+}
+
+// FindIDContext is FindID, giving up with the context's error once it's done, without trying
+// another id. A store that's already started can't be interrupted, so a late success still
+// leaves the value in the cache until it expires.
+func (r *RandomCache) FindIDContext(ctx context.Context, val string) (int, error) {
 	attempt := 0
 	for {
 		rec := int(rand.Int63())
-		if err := r.Store(strconv.Itoa(rec), val); err != nil && attempt > 5 {
+		err := WithContext(ctx, func() error { return r.Store(strconv.Itoa(rec), val) })
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, ctxErr
+		}
+		if err != nil && attempt > 5 {
 			return 0, err
 		} else if err == nil {
 			return rec, nil
@@ -43,6 +55,36 @@ func (r *RandomCache) FindID(val string) (int, error) {
 	}
 }
 
+func (r *RandomCache) LoadContext(ctx context.Context, keyStr string) (string, error) {
+	var val string
+	err := WithContext(ctx, func() (err error) {
+		val, err = r.Load(keyStr)
+		return
+	})
+	return val, err
+}
+
+// WithContext runs f, returning early with the context's error if it's done first.
+func WithContext(ctx context.Context, f func() error) error {
+	if ctx.Done() == nil {
+		// it can't be done, so there's no need for a goroutine
+		return f()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 type ShardSystem struct {
 	Children   []CacheSystem
 	Fallback   CacheSystem
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Counters are per ssp counts of events, like requests and the reasons they weren't bid on.
+// The report gives each count as a rate of that ssp's "requests".
+type Counters struct {
+	lock   sync.Mutex
+	counts map[int]map[string]uint64
+}
+
+func (c *Counters) Inc(ssp int, name string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[int]map[string]uint64)
+	}
+	if c.counts[ssp] == nil {
+		c.counts[ssp] = make(map[string]uint64)
+	}
+	c.counts[ssp][name]++
+}
+
+func (c *Counters) Get(ssp int, name string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.counts[ssp][name]
+}
+
+func (c *Counters) String() string {
+	if c == nil {
+		return ""
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ssps := make([]int, 0, len(c.counts))
+	for ssp := range c.counts {
+		ssps = append(ssps, ssp)
+	}
+	sort.Ints(ssps)
+
+	str := []string{}
+	for _, ssp := range ssps {
+		counts := c.counts[ssp]
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts := []string{}
+		for _, name := range names {
+			if total := counts["requests"]; total > 0 && name != "requests" {
+				parts = append(parts, fmt.Sprintf(`%s %d (%.2f%%)`, name, counts[name], 100*float64(counts[name])/float64(total)))
+			} else {
+				parts = append(parts, fmt.Sprintf(`%s %d`, name, counts[name]))
+			}
+		}
+		str = append(str, fmt.Sprintf(`ssp %d: %s`, ssp, strings.Join(parts, ", ")))
+	}
+	return strings.Join(str, "\n")
+}
+
+func (c *Counters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(200)
+	w.Write([]byte(c.String()))
+}
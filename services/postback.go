@@ -0,0 +1,276 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery is a postback waiting to be (re)sent.
+type Delivery struct {
+	ID       int64
+	Event    string
+	URL      string
+	Attempts int
+}
+
+// PostbackQueue keeps deliveries until they're sent or given up on, logging every attempt.
+type PostbackQueue interface {
+	Push(d *Delivery) error
+	// Due claims up to limit deliveries due by now, so they aren't handed out again for a while.
+	Due(now time.Time, limit int) ([]*Delivery, error)
+	// Attempted logs an attempt, and either finishes the delivery (next is nil) or retries it at next.
+	Attempted(d *Delivery, status int, err error, next *time.Time) error
+}
+
+// Postbacks notifies publishers of events. Notify expands a url's {macros} and queues it, Launch
+// sends what's due every Interval. Failures (errors or non 2xx) are retried after Backoff,
+// doubling each time, until MaxAttempts. Queue can be replaced while running with SetQueue.
+type Postbacks struct {
+	Queue       PostbackQueue
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+	Interval    time.Duration
+	Messages    chan string
+
+	lock sync.RWMutex
+}
+
+// ErrNoQueue is returned by Notify before there's a Queue.
+var ErrNoQueue = errors.New("postbacks have no queue yet")
+
+func (p *Postbacks) SetQueue(q PostbackQueue) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.Queue = q
+}
+
+// UseDB queues in db (see SQLQueue), or in memory while there's no db. Deliveries queued in memory
+// are kept when it's called again without one, but don't outlive the process.
+func (p *Postbacks) UseDB(db *sql.DB) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if db != nil {
+		p.Queue = SQLQueue{DB: db}
+	} else if _, ok := p.Queue.(*MemoryQueue); !ok {
+		p.Queue = &MemoryQueue{}
+	}
+}
+
+func (p *Postbacks) queue() PostbackQueue {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.Queue
+}
+
+func (p *Postbacks) defaults() {
+	if p.Client == nil {
+		p.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 8
+	}
+	if p.Backoff == 0 {
+		p.Backoff = 30 * time.Second
+	}
+	if p.Interval == 0 {
+		p.Interval = 5 * time.Second
+	}
+}
+
+// ExpandMacros replaces each {name} in tmpl with the query escaped value. Unknown macros are left.
+func ExpandMacros(tmpl string, values map[string]string) string {
+	for name, v := range values {
+		tmpl = strings.Replace(tmpl, "{"+name+"}", url.QueryEscape(v), -1)
+	}
+	return tmpl
+}
+
+// Notify queues the event's postback to tmpl. values gets an "event" macro added.
+func (p *Postbacks) Notify(event, tmpl string, values map[string]string) error {
+	if values == nil {
+		values = map[string]string{}
+	}
+	values["event"] = event
+	q := p.queue()
+	if q == nil {
+		return ErrNoQueue
+	}
+	return q.Push(&Delivery{Event: event, URL: ExpandMacros(tmpl, values)})
+}
+
+// Flush sends up to limit due deliveries, returning how many were delivered. Nothing is sent until
+// there's a Queue.
+func (p *Postbacks) Flush(now time.Time, limit int) (int, error) {
+	p.defaults()
+	q := p.queue()
+	if q == nil {
+		return 0, nil
+	}
+	due, err := q.Due(now, limit)
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, d := range due {
+		d.Attempts++
+		status, err := p.send(d)
+		var next *time.Time
+		if err != nil && d.Attempts < p.MaxAttempts {
+			at := now.Add(p.Backoff << uint(d.Attempts-1))
+			next = &at
+		} else if err == nil {
+			delivered++
+		}
+		if err := q.Attempted(d, status, err, next); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+func (p *Postbacks) send(d *Delivery) (int, error) {
+	res, err := p.Client.Get(d.URL)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf(`postback %s answered %d`, d.Event, res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+func (p *Postbacks) Launch(errs chan error) error {
+	p.defaults()
+	p.Messages <- "launching postbacks"
+	go func() {
+		for now := range time.NewTicker(p.Interval).C {
+			for {
+				n, err := p.Flush(now, 100)
+				if err != nil {
+					p.Messages <- "postbacks: " + err.Error()
+					break
+				}
+				if n < 100 {
+					break
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// MemoryQueue is a PostbackQueue that doesn't outlive the process, Log holds every attempt.
+type MemoryQueue struct {
+	Log []string
+
+	lock    sync.Mutex
+	nextID  int64
+	pending map[int64]*Delivery
+	due     map[int64]time.Time
+}
+
+func (q *MemoryQueue) Push(d *Delivery) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.pending == nil {
+		q.pending, q.due = make(map[int64]*Delivery), make(map[int64]time.Time)
+	}
+	q.nextID++
+	d.ID = q.nextID
+	q.pending[d.ID] = d
+	q.due[d.ID] = time.Time{}
+	return nil
+}
+
+func (q *MemoryQueue) Due(now time.Time, limit int) ([]*Delivery, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var due []*Delivery
+	for id, d := range q.pending {
+		if len(due) < limit && !q.due[id].After(now) {
+			due = append(due, d)
+			q.due[id] = now.Add(5 * time.Minute)
+		}
+	}
+	return due, nil
+}
+
+func (q *MemoryQueue) Attempted(d *Delivery, status int, err error, next *time.Time) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.Log = append(q.Log, fmt.Sprintf(`%d %s attempt %d: %d %v`, d.ID, d.Event, d.Attempts, status, err))
+	if next == nil {
+		delete(q.pending, d.ID)
+		delete(q.due, d.ID)
+	} else {
+		q.due[d.ID] = *next
+	}
+	return nil
+}
+
+// Len is how many deliveries are still queued.
+func (q *MemoryQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.pending)
+}
+
+const sqlPushPostback = `INSERT INTO postbacks (event, url) VALUES ($1, $2) RETURNING id`
+const sqlDuePostbacks = `UPDATE postbacks SET next_at = $1::timestamp + INTERVAL '5 minutes' WHERE id IN (SELECT id FROM postbacks WHERE finished_at IS NULL AND next_at <= $1 ORDER BY next_at LIMIT $2 FOR UPDATE SKIP LOCKED) RETURNING id, event, url, attempts`
+const sqlLogPostback = `INSERT INTO postback_log (postback_id, attempt, status, error) VALUES ($1, $2, $3, $4)`
+const sqlRetryPostback = `UPDATE postbacks SET attempts = $2, last_status = $3, last_error = $4, next_at = $5 WHERE id = $1`
+const sqlFinishPostback = `UPDATE postbacks SET attempts = $2, last_status = $3, last_error = $4, finished_at = NOW() WHERE id = $1`
+
+// SQLQueue keeps the queue in the postbacks table of the stats db, and the log in postback_log.
+// Claimed deliveries are leased for five minutes, so several instances can share the queue.
+type SQLQueue struct {
+	DB *sql.DB
+}
+
+func (q SQLQueue) Push(d *Delivery) error {
+	return q.DB.QueryRow(sqlPushPostback, d.Event, d.URL).Scan(&d.ID)
+}
+
+func (q SQLQueue) Due(now time.Time, limit int) ([]*Delivery, error) {
+	rows, err := q.DB.Query(sqlDuePostbacks, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var due []*Delivery
+	for rows.Next() {
+		d := &Delivery{}
+		if err := rows.Scan(&d.ID, &d.Event, &d.URL, &d.Attempts); err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+func (q SQLQueue) Attempted(d *Delivery, status int, err error, next *time.Time) error {
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	if _, err := q.DB.Exec(sqlLogPostback, d.ID, d.Attempts, status, msg); err != nil {
+		return err
+	}
+	if next == nil {
+		_, err = q.DB.Exec(sqlFinishPostback, d.ID, d.Attempts, status, msg)
+	} else {
+		_, err = q.DB.Exec(sqlRetryPostback, d.ID, d.Attempts, status, msg, *next)
+	}
+	return err
+}
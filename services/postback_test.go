@@ -0,0 +1,78 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPostbacks(t *testing.T) {
+	var hits []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, r.URL.RawQuery)
+		if len(hits) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	q := &MemoryQueue{}
+	p := &Postbacks{Queue: q, MaxAttempts: 3, Backoff: time.Minute}
+	if err := p.Notify("click", srv.URL+"/pb?e={event}&id={clickid}&x={unknown}", map[string]string{"clickid": "a b&c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if n, err := p.Flush(now, 10); n != 0 || err != nil || q.Len() != 1 {
+		t.Fatalf(`first attempt should fail and stay queued, delivered %d, %v`, n, err)
+	}
+	if n, _ := p.Flush(now.Add(30*time.Second), 10); n != 0 || len(hits) != 1 {
+		t.Error("retried before the backoff")
+	}
+	if n, _ := p.Flush(now.Add(2*time.Minute), 10); n != 1 || q.Len() != 0 {
+		t.Error("retry wasn't delivered")
+	}
+	if hits[1] != "e=click&id=a+b%26c&x={unknown}" {
+		t.Errorf(`sent %s`, hits[1])
+	}
+	if len(q.Log) != 2 {
+		t.Errorf(`expected 2 logged attempts, have %v`, q.Log)
+	}
+
+	p.Notify("win", "http://127.0.0.1:1/nothing", nil)
+	for i := 0; i < 5; i++ {
+		p.Flush(now.Add(time.Duration(i+3)*time.Hour), 10)
+	}
+	if q.Len() != 0 || len(q.Log) != 5 {
+		t.Errorf(`expected 3 attempts then giving up, have %v`, q.Log)
+	}
+}
+
+func TestPostbacksWithoutQueue(t *testing.T) {
+	p := &Postbacks{}
+	if err := p.Notify("win", "http://example.com/{event}", nil); err != ErrNoQueue {
+		t.Errorf(`expected ErrNoQueue, got %v`, err)
+	}
+	if n, err := p.Flush(time.Now(), 10); n != 0 || err != nil {
+		t.Errorf(`flushed %d without a queue, %v`, n, err)
+	}
+	q := &MemoryQueue{}
+	p.SetQueue(q)
+	if err := p.Notify("win", "http://example.com/{event}", nil); err != nil || q.Len() != 1 {
+		t.Errorf(`didn't queue once there's a queue, %v`, err)
+	}
+}
+
+func TestPostbacksUseDB(t *testing.T) {
+	p := &Postbacks{}
+	p.UseDB(nil)
+	if err := p.Notify("win", "http://example.com/{event}", nil); err != nil {
+		t.Fatalf(`without a stats db postbacks aren't queued in memory, %v`, err)
+	}
+	q := p.queue()
+	p.UseDB(nil)
+	if p.queue() != q || q.(*MemoryQueue).Len() != 1 {
+		t.Error("the memory queue was replaced")
+	}
+}
@@ -1,9 +1,12 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestRetryWithSharding(t *testing.T) {
@@ -73,3 +76,33 @@ func TestPicks(t *testing.T) {
 	sh.Pick("9e0mCxci7xnttCYfFkUtHVaExZg=")
 	sh.Pick("hello worlh")
 }
+
+func TestWithContext(t *testing.T) {
+	if err := WithContext(context.Background(), func() error { return errors.New("failed") }); err == nil || err.Error() != "failed" {
+		t.Errorf(`expected f's error, got %v`, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	if err := WithContext(ctx, func() error { <-release; return nil }); err != context.DeadlineExceeded {
+		t.Errorf(`expected the deadline, got %v`, err)
+	}
+	called := false
+	if err := WithContext(ctx, func() error { called = true; return nil }); err == nil || called {
+		t.Error("ran f after the context was done")
+	}
+}
+
+func TestFindIDContext(t *testing.T) {
+	failing := &CountingCache{Callback: func(int, interface{}) (string, error) { return "", errors.New("taken") }}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&RandomCache{failing}).FindIDContext(ctx, "v"); err != context.Canceled || failing.n != 0 {
+		t.Errorf(`a done context got %v after %d stores`, err, failing.n)
+	}
+	if _, err := (&RandomCache{failing}).FindID("v"); err == nil || failing.n != 7 {
+		t.Errorf(`expected to give up after 7 stores, got %v after %d`, err, failing.n)
+	}
+}
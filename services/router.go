@@ -4,15 +4,20 @@ import (
 	"net/http"
 )
 
+// RouterService serves Mux on Addr, :8080 if unset.
 type RouterService struct {
 	Messages chan string
 	Mux      *http.ServeMux
+	Addr     string
 }
 
 func (r *RouterService) Launch(errs chan error) error {
-	r.Messages <- "launching router"
+	if r.Addr == "" {
+		r.Addr = ":8080"
+	}
+	r.Messages <- "launching router on " + r.Addr
 	go func() {
-		errs <- http.ListenAndServe(":8080", r.Mux)
+		errs <- http.ListenAndServe(r.Addr, r.Mux)
 	}()
 	return nil
 }
@@ -0,0 +1,172 @@
+package tracking
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/services"
+	"gopkg.in/redis.v5"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Creatives holds the creatives clicks are redirected to, reloaded from the config db each cycle.
+type Creatives struct {
+	BindingDeps services.BindingDeps
+
+	lock      sync.RWMutex
+	creatives bindings.Creatives
+}
+
+func (c *Creatives) Cycle(quit func(error) bool) {
+	if c.BindingDeps.ConfigDB == nil {
+		return
+	}
+	creatives := bindings.Creatives{}
+	if err := creatives.Unmarshal(0, c.BindingDeps); quit(services.ErrDatabaseMissing{Name: "creatives", UnderlyingErr: err}) {
+		return
+	}
+	c.lock.Lock()
+	c.creatives = creatives
+	c.lock.Unlock()
+}
+
+func (c *Creatives) ByID(id int) *bindings.Creative {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.creatives.ByID(id)
+}
+
+// ClickRecorder stores a click, bindings.Clicks in production.
+type ClickRecorder interface {
+	Save(ctx context.Context, clickID string, t *bindings.ClickToken) error
+}
+
+// Redirects is the click endpoint. Bids point their rurl at URL, which carries a sealed
+// ClickToken; the click is given an id, recorded, handed to OnClick and the user is sent on to the
+// creative's destination with its macros expanded. Tokens that don't open are a 400, expired ones
+// a 410 and clicks on creatives that aren't live a 404. Repeat clicks on a token are sent on with
+// the first click's id but not recorded again; they're remembered in KVS, or in memory without it.
+type Redirects struct {
+	Base      string
+	Sealer    *bindings.Sealer
+	TTL       time.Duration
+	Creatives *Creatives
+	Clicks    ClickRecorder
+	OnClick   []func(clickID string, t *bindings.ClickToken)
+	KVS       *redis.Client
+	Counters  *services.Counters
+	Messages  chan string
+
+	lock        sync.Mutex
+	clicked     map[string]string
+	lastClicked map[string]string
+	rotated     time.Time
+}
+
+// URL seals the token (expiring it after TTL, 24 hours by default) into a click url.
+func (rd *Redirects) URL(t *bindings.ClickToken) (string, error) {
+	ttl := rd.TTL
+	if ttl == 0 {
+		ttl = 24 * time.Hour
+	}
+	t.Expires = time.Now().Add(ttl).Unix()
+	if t.ID == "" {
+		t.ID = NewClickID()
+	}
+	token, err := rd.Sealer.Seal(t)
+	if err != nil {
+		return "", err
+	}
+	return rd.Base + "?t=" + url.QueryEscape(token), nil
+}
+
+func (rd *Redirects) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var t bindings.ClickToken
+	if err := rd.Sealer.Open(r.URL.Query().Get("t"), &t); err != nil {
+		rd.Counters.Inc(0, "click_invalid")
+		http.Error(w, "invalid click", http.StatusBadRequest)
+		return
+	}
+	if t.Expired(time.Now()) {
+		rd.Counters.Inc(t.SSPID, "click_expired")
+		http.Error(w, "click expired", http.StatusGone)
+		return
+	}
+	creative := rd.Creatives.ByID(t.CreativeID)
+	if creative == nil || !creative.Active {
+		rd.Counters.Inc(t.SSPID, "click_no_creative")
+		http.NotFound(w, r)
+		return
+	}
+
+	clickID, first := rd.firstClick(&t, NewClickID())
+	if !first {
+		rd.Counters.Inc(t.SSPID, "click_repeat")
+		http.Redirect(w, r, creative.Expand(t.Macros(clickID)), http.StatusFound)
+		return
+	}
+	if rd.Clicks != nil {
+		if err := rd.Clicks.Save(r.Context(), clickID, &t); err != nil {
+			rd.Messages <- "couldn't record click " + clickID + ": " + err.Error()
+		}
+	}
+	for _, f := range rd.OnClick {
+		f(clickID, &t)
+	}
+	rd.Counters.Inc(t.SSPID, "clicks")
+	http.Redirect(w, r, creative.Expand(t.Macros(clickID)), http.StatusFound)
+}
+
+// firstClick remembers clickID as the token's click until the token expires. If the token was
+// clicked before it returns that click's id and false.
+func (rd *Redirects) firstClick(t *bindings.ClickToken, clickID string) (string, bool) {
+	if t.ID == "" {
+		return clickID, true
+	}
+	ttl := time.Unix(t.Expires, 0).Sub(time.Now()) + time.Second
+	if rd.KVS != nil {
+		if ok, err := rd.KVS.SetNX("click:"+t.ID, clickID, ttl).Result(); err == nil {
+			if ok {
+				return clickID, true
+			}
+			if first, err := rd.KVS.Get("click:" + t.ID).Result(); err == nil {
+				return first, false
+			}
+			return clickID, false
+		}
+	}
+
+	// tokens live at most TTL, so two generations of that long cover them
+	rd.lock.Lock()
+	defer rd.lock.Unlock()
+	window := rd.TTL
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+	if now := time.Now(); rd.clicked == nil || now.Sub(rd.rotated) > window {
+		rd.lastClicked = rd.clicked
+		if now.Sub(rd.rotated) > 2*window {
+			rd.lastClicked = nil
+		}
+		rd.clicked, rd.rotated = make(map[string]string), now
+	}
+	if first, ok := rd.clicked[t.ID]; ok {
+		return first, false
+	}
+	if first, ok := rd.lastClicked[t.ID]; ok {
+		return first, false
+	}
+	rd.clicked[t.ID] = clickID
+	return clickID, true
+}
+
+// NewClickID is a random 16 byte hex id.
+func NewClickID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
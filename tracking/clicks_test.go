@@ -0,0 +1,68 @@
+package tracking
+
+import (
+	"context"
+	"github.com/clixxa/dsp/bindings"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type savedClicks []string
+
+func (s *savedClicks) Save(ctx context.Context, clickID string, t *bindings.ClickToken) error {
+	*s = append(*s, clickID)
+	return nil
+}
+
+func TestRedirects(t *testing.T) {
+	creatives := &Creatives{creatives: bindings.Creatives{
+		{ID: 3, RedirectUrl: "http://adv.com/land?cid={clickid}&kw={keyword}", Active: true},
+		{ID: 4, RedirectUrl: "http://adv.com/old", Active: false},
+	}}
+	saved := &savedClicks{}
+	rd := &Redirects{Base: "http://us.com/click", Sealer: &bindings.Sealer{Key: []byte("k")}, Creatives: creatives, Clicks: saved, Messages: make(chan string, 10)}
+
+	get := func(u string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rd.ServeHTTP(w, httptest.NewRequest("GET", u, nil))
+		return w
+	}
+
+	u, _ := rd.URL(&bindings.ClickToken{SaleID: 1, CreativeID: 3, Keyword: "red cars"})
+	w := get(u)
+	if w.Code != http.StatusFound || len(*saved) != 1 {
+		t.Fatalf(`expected a recorded redirect, have %d`, w.Code)
+	}
+	want := "http://adv.com/land?cid=" + (*saved)[0] + "&kw=red+cars"
+	if w.Header().Get("Location") != want {
+		t.Errorf(`redirected to %s, not %s`, w.Header().Get("Location"), want)
+	}
+
+	// a repeat click goes through with the same click id, but isn't recorded twice
+	if w := get(u); w.Code != http.StatusFound || w.Header().Get("Location") != want {
+		t.Errorf(`repeat click gave %d to %s`, w.Code, w.Header().Get("Location"))
+	}
+	if len(*saved) != 1 {
+		t.Errorf(`recorded a repeat click`)
+	}
+
+	if w := get(strings.Replace(u, "t=", "t=x", 1)); w.Code != http.StatusBadRequest {
+		t.Errorf(`tampered token gave %d`, w.Code)
+	}
+	rd.TTL = -time.Minute
+	u, _ = rd.URL(&bindings.ClickToken{SaleID: 2, CreativeID: 3})
+	if w := get(u); w.Code != http.StatusGone {
+		t.Errorf(`expired token gave %d`, w.Code)
+	}
+	rd.TTL = 0
+	u, _ = rd.URL(&bindings.ClickToken{SaleID: 3, CreativeID: 4})
+	if w := get(u); w.Code != http.StatusNotFound {
+		t.Errorf(`paused creative gave %d`, w.Code)
+	}
+	if len(*saved) != 1 {
+		t.Errorf(`recorded %d clicks, expected 1`, len(*saved))
+	}
+}
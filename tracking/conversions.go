@@ -0,0 +1,83 @@
+package tracking
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// maxConversionValue is the most a conversion can be worth, in home currency, and still fit the
+// value column in wire units.
+const maxConversionValue = math.MaxInt32 / rtb_types.PriceScale
+
+// ConversionRecorder attributes and stores a conversion, bindings.Conversions in production.
+type ConversionRecorder interface {
+	Save(ctx context.Context, c *bindings.Conversion) error
+}
+
+// ConversionPostback is the /conversion endpoint advertisers call, with the click_id we gave their
+// landing page or a sale_id, and optionally a txid and value (in home currency, not negative). With a Key set it
+// must be passed as key. Repeats of a recorded conversion are answered 200 so retries are safe, but
+// only attributed conversions are handed to OnConversion.
+type ConversionPostback struct {
+	Conversions  ConversionRecorder
+	Key          string
+	OnConversion []func(c *bindings.Conversion)
+	Counters     *services.Counters
+	Messages     chan string
+}
+
+func (cp *ConversionPostback) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if cp.Key != "" && subtle.ConstantTimeCompare([]byte(q.Get("key")), []byte(cp.Key)) != 1 {
+		cp.Counters.Inc(0, "conversion_403")
+		http.Error(w, "bad key", http.StatusForbidden)
+		return
+	}
+
+	c := &bindings.Conversion{ClickID: q.Get("click_id"), TxID: q.Get("txid")}
+	if c.ClickID == "" {
+		id, err := strconv.Atoi(q.Get("sale_id"))
+		if err != nil {
+			cp.Counters.Inc(0, "conversion_invalid")
+			http.Error(w, "need a click_id or sale_id", http.StatusBadRequest)
+			return
+		}
+		c.SaleID = id
+	}
+	if v := q.Get("value"); v != "" {
+		value, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(value) || value < 0 || value > maxConversionValue {
+			cp.Counters.Inc(0, "conversion_invalid")
+			http.Error(w, "bad value", http.StatusBadRequest)
+			return
+		}
+		c.Value = int(value * rtb_types.PriceScale)
+	}
+
+	switch err := cp.Conversions.Save(r.Context(), c); err {
+	case nil:
+		cp.Counters.Inc(c.SSPID, "conversions")
+		for _, f := range cp.OnConversion {
+			f(c)
+		}
+		w.Write([]byte("ok"))
+	case bindings.ErrDuplicateConvert:
+		cp.Counters.Inc(0, "conversion_duplicate")
+		w.Write([]byte("duplicate"))
+	case bindings.ErrUnknownSale:
+		cp.Counters.Inc(0, "conversion_unknown")
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case bindings.ErrOutsideWindow:
+		cp.Counters.Inc(0, "conversion_late")
+		http.Error(w, err.Error(), http.StatusGone)
+	default:
+		cp.Messages <- "couldn't record conversion: " + err.Error()
+		http.Error(w, "try again", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,58 @@
+package tracking
+
+import (
+	"context"
+	"github.com/clixxa/dsp/bindings"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+type savedConversions map[string]bool
+
+func (s savedConversions) Save(ctx context.Context, c *bindings.Conversion) error {
+	if c.ClickID == "unknown" {
+		return bindings.ErrUnknownSale
+	}
+	key := c.ClickID + strconv.Itoa(c.SaleID) + "/" + c.TxID
+	if s[key] {
+		return bindings.ErrDuplicateConvert
+	}
+	s[key] = true
+	c.FolderID = 7
+	return nil
+}
+
+func TestConversionPostback(t *testing.T) {
+	var converted []*bindings.Conversion
+	cp := &ConversionPostback{Conversions: savedConversions{}, Key: "secret", OnConversion: []func(*bindings.Conversion){func(c *bindings.Conversion) {
+		converted = append(converted, c)
+	}}}
+	for _, tc := range []struct {
+		query string
+		code  int
+	}{
+		{"?click_id=abc&key=secret&value=1.5", http.StatusOK},
+		{"?click_id=abc&key=secret", http.StatusOK},
+		{"?sale_id=12&txid=1&key=secret", http.StatusOK},
+		{"?sale_id=12&txid=2&key=secret", http.StatusOK},
+		{"?click_id=abc", http.StatusForbidden},
+		{"?key=secret", http.StatusBadRequest},
+		{"?click_id=abc&key=secret&value=lots", http.StatusBadRequest},
+		{"?click_id=abc&key=secret&value=NaN", http.StatusBadRequest},
+		{"?click_id=abc&key=secret&value=Inf", http.StatusBadRequest},
+		{"?click_id=abc&key=secret&value=-2", http.StatusBadRequest},
+		{"?click_id=abc&key=secret&value=1e300", http.StatusBadRequest},
+		{"?click_id=unknown&key=secret", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		cp.ServeHTTP(w, httptest.NewRequest("GET", "/conversion"+tc.query, nil))
+		if w.Code != tc.code {
+			t.Errorf(`%s gave %d, expected %d`, tc.query, w.Code, tc.code)
+		}
+	}
+	if len(converted) != 3 || converted[0].Value != 1500 || converted[0].FolderID != 7 {
+		t.Errorf(`expected 3 attributed conversions, have %d`, len(converted))
+	}
+}
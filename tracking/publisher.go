@@ -0,0 +1,114 @@
+package tracking
+
+import (
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/rtb_types"
+	"github.com/clixxa/dsp/services"
+	"strconv"
+	"sync"
+)
+
+// Users looks up an ssp's settings, gateway.SSPs in production.
+type Users interface {
+	ByID(id int) *bindings.User
+}
+
+// Publisher fires the ssp's PublisherURL on the events it wants. Win is called from the
+// gateway.Wins hook, Click and Conversion fit the Redirects and ConversionPostback hooks. The url can use {event},
+// {saleid}, {sspid}, {folderid}, {creativeid}, {clickid}, {price}, {txid} and {value}. Postbacks are
+// handed to Launch to queue, so the hooks never wait on the queue; one that doesn't fit (QueueSize,
+// 10000 by default) is dropped, counted as postback_dropped.
+type Publisher struct {
+	SSPs      Users
+	Postbacks *services.Postbacks
+	QueueSize int
+	Counters  *services.Counters
+	Messages  chan string
+
+	once    sync.Once
+	pending chan notice
+}
+
+type notice struct {
+	event, tmpl string
+	values      map[string]string
+}
+
+func (p *Publisher) queued() chan notice {
+	p.once.Do(func() {
+		if p.QueueSize == 0 {
+			p.QueueSize = 10000
+		}
+		p.pending = make(chan notice, p.QueueSize)
+	})
+	return p.pending
+}
+
+func (p *Publisher) notify(event string, ssp int, values map[string]string) {
+	tmpl, ok := p.SSPs.ByID(ssp).PostbackURL(event)
+	if !ok {
+		return
+	}
+	values["sspid"] = strconv.Itoa(ssp)
+	select {
+	case p.queued() <- notice{event: event, tmpl: tmpl, values: values}:
+	default:
+		p.Counters.Inc(ssp, "postback_dropped")
+	}
+}
+
+func (p *Publisher) queue(n notice) {
+	if err := p.Postbacks.Notify(n.event, n.tmpl, n.values); err != nil {
+		p.Messages <- "couldn't queue postback: " + err.Error()
+	}
+}
+
+// Flush queues the postbacks waiting for Launch, returning how many.
+func (p *Publisher) Flush() int {
+	pending := p.queued()
+	n := 0
+	for ; len(pending) > 0; n++ {
+		p.queue(<-pending)
+	}
+	return n
+}
+
+func (p *Publisher) Launch(errs chan error) error {
+	p.Messages <- "launching publisher"
+	go func() {
+		for n := range p.queued() {
+			p.queue(n)
+		}
+	}()
+	return nil
+}
+
+// Win takes the clearing price in wire units.
+func (p *Publisher) Win(saleID, ssp, folderID, creativeID int, price float64) {
+	p.notify("win", ssp, map[string]string{
+		"saleid":     strconv.Itoa(saleID),
+		"folderid":   strconv.Itoa(folderID),
+		"creativeid": strconv.Itoa(creativeID),
+		"price":      strconv.FormatFloat(rtb_types.RPM(price), 'f', -1, 64),
+	})
+}
+
+func (p *Publisher) Click(clickID string, t *bindings.ClickToken) {
+	p.notify("click", t.SSPID, map[string]string{
+		"saleid":     strconv.Itoa(t.SaleID),
+		"folderid":   strconv.Itoa(t.FolderID),
+		"creativeid": strconv.Itoa(t.CreativeID),
+		"clickid":    clickID,
+	})
+}
+
+func (p *Publisher) Conversion(c *bindings.Conversion) {
+	p.notify("conversion", c.SSPID, map[string]string{
+		"saleid":     strconv.Itoa(c.SaleID),
+		"folderid":   strconv.Itoa(c.FolderID),
+		"creativeid": strconv.Itoa(c.CreativeID),
+		"clickid":    c.ClickID,
+		"txid":       c.TxID,
+		"value":      strconv.FormatFloat(float64(c.Value)/rtb_types.PriceScale, 'f', -1, 64),
+	})
+}
@@ -0,0 +1,60 @@
+package tracking
+
+import (
+	"github.com/clixxa/dsp/bindings"
+	"github.com/clixxa/dsp/services"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPublisher(t *testing.T) {
+	q := &services.MemoryQueue{}
+	p := &Publisher{SSPs: &bindings.Users{
+		{ID: 1, PublisherURL: "http://ssp1.com/pb?e={event}&sale={saleid}&f={folderid}&p={price}&c={clickid}"},
+		{ID: 2, PublisherURL: "http://ssp2.com/pb?e={event}", Postbacks: bindings.ParsePostbackEvents(" Click, ,conversion")},
+		{ID: 3},
+	}, Postbacks: &services.Postbacks{Queue: q}, Messages: make(chan string, 10)}
+
+	for _, ssp := range []int{1, 2, 3, 4} {
+		p.Win(10, ssp, 5, 6, 1500)
+		p.Click("abc", &bindings.ClickToken{SaleID: 10, SSPID: ssp, FolderID: 5})
+		p.Conversion(&bindings.Conversion{SaleID: 10, SSPID: ssp, ClickID: "abc"})
+	}
+
+	if n := p.Flush(); n != 5 {
+		t.Fatalf(`queued %d postbacks`, n)
+	}
+	due, _ := q.Due(time.Now(), 100)
+	var sent []string
+	for _, d := range due {
+		sent = append(sent, d.URL)
+	}
+	sort.Strings(sent)
+	want := []string{
+		"http://ssp1.com/pb?e=click&sale=10&f=5&p={price}&c=abc",
+		"http://ssp1.com/pb?e=conversion&sale=10&f=0&p={price}&c=abc",
+		"http://ssp1.com/pb?e=win&sale=10&f=5&p=1.5&c={clickid}",
+		"http://ssp2.com/pb?e=click",
+		"http://ssp2.com/pb?e=conversion",
+	}
+	if len(sent) != len(want) {
+		t.Fatalf(`sent %v`, sent)
+	}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Errorf(`sent %s, expected %s`, sent[i], want[i])
+		}
+	}
+}
+
+func TestPublisherDoesntWait(t *testing.T) {
+	counters := &services.Counters{}
+	p := &Publisher{SSPs: &bindings.Users{{ID: 1, PublisherURL: "http://ssp1.com/pb?e={event}"}}, Postbacks: &services.Postbacks{}, QueueSize: 1, Counters: counters, Messages: make(chan string, 10)}
+	// with nothing taking them, the second win's postback is dropped rather than waited on
+	p.Win(10, 1, 5, 6, 1500)
+	p.Win(11, 1, 5, 6, 1500)
+	if counters.Get(1, "postback_dropped") != 1 {
+		t.Error("the postback that didn't fit wasn't dropped")
+	}
+}